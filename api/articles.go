@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"tidy/ent"
+	"tidy/ent/article"
+	"tidy/ent/delivery"
+	"tidy/ent/user"
+)
+
+// articlesMutex évite les écritures concurrentes des scrapers d'un même cron job (SQLite n'accepte qu'une écriture à la fois)
+var articlesMutex sync.Mutex
+
+// saveArticles enregistre les nouveaux articles récupérés par un scraper.
+// Elle retourne tous les articles présents sur la page ainsi que le nombre d'articles créés.
+func saveArticles(ctx context.Context, client *ent.Client, scraperDetails *ent.Scraper, blogs []map[string]interface{}) ([]*ent.Article, int, error) {
+	articlesMutex.Lock()
+	defer articlesMutex.Unlock()
+
+	// Dédoublonnage des articles de la page par lien canonique
+	links := make([]string, 0, len(blogs))
+	byLink := make(map[string]map[string]interface{})
+	for _, blog := range blogs {
+		link := canonicalLink(fmt.Sprintf("%v", blog["link"]))
+		if link == "" {
+			continue
+		}
+		if _, exists := byLink[link]; exists {
+			continue
+		}
+		byLink[link] = blog
+		links = append(links, link)
+	}
+
+	if len(links) == 0 {
+		return []*ent.Article{}, 0, nil
+	}
+
+	// Récupérer les liens déjà connus
+	known, err := client.Article.Query().
+		Where(article.LinkIn(links...)).
+		Select(article.FieldLink).
+		Strings(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erreur lors de la récupération des articles existants: %v", err)
+	}
+
+	knownLinks := make(map[string]bool, len(known))
+	for _, link := range known {
+		knownLinks[link] = true
+	}
+
+	builders := make([]*ent.ArticleCreate, 0)
+	for _, link := range links {
+		if knownLinks[link] {
+			continue
+		}
+		blog := byLink[link]
+		create := client.Article.Create().
+			SetTitle(strings.TrimSpace(fmt.Sprintf("%v", blog["title"]))).
+			SetDescription(strings.TrimSpace(fmt.Sprintf("%v", blog["description"]))).
+			SetImage(fmt.Sprintf("%v", blog["image"])).
+			SetTime(strings.TrimSpace(fmt.Sprintf("%v", blog["time"]))).
+			SetLink(link).
+			SetScraper(scraperDetails)
+		if extra, ok := blog["extra"].(map[string]string); ok && len(extra) > 0 {
+			create.SetExtra(extra)
+		}
+		builders = append(builders, create)
+	}
+
+	if len(builders) > 0 {
+		if _, err := client.Article.CreateBulk(builders...).Save(ctx); err != nil {
+			return nil, 0, fmt.Errorf("erreur lors de l'enregistrement des articles: %v", err)
+		}
+	}
+
+	articles, err := client.Article.Query().
+		Where(article.LinkIn(links...)).
+		Order(ent.Desc(article.FieldFirstSeenAt), ent.Asc(article.FieldID)).
+		All(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erreur lors de la récupération des articles: %v", err)
+	}
+
+	return articles, len(builders), nil
+}
+
+// undeliveredArticles filtre les articles qui n'ont encore jamais été envoyés à l'utilisateur
+func undeliveredArticles(ctx context.Context, client *ent.Client, u *ent.User, articles []*ent.Article) ([]*ent.Article, error) {
+	if len(articles) == 0 {
+		return []*ent.Article{}, nil
+	}
+
+	ids := make([]int, len(articles))
+	for i, a := range articles {
+		ids[i] = a.ID
+	}
+
+	delivered, err := client.Delivery.Query().
+		Where(
+			delivery.HasUserWith(user.IDEQ(u.ID)),
+			delivery.HasArticleWith(article.IDIn(ids...)),
+		).
+		QueryArticle().
+		IDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des envois de %s: %v", u.Email, err)
+	}
+
+	deliveredIDs := make(map[int]bool, len(delivered))
+	for _, id := range delivered {
+		deliveredIDs[id] = true
+	}
+
+	result := make([]*ent.Article, 0, len(articles))
+	for _, a := range articles {
+		if !deliveredIDs[a.ID] {
+			result = append(result, a)
+		}
+	}
+
+	return result, nil
+}
+
+// markDelivered enregistre l'envoi des articles à l'utilisateur
+func markDelivered(ctx context.Context, client *ent.Client, u *ent.User, articles []*ent.Article) error {
+	builders := make([]*ent.DeliveryCreate, len(articles))
+	for i, a := range articles {
+		builders[i] = client.Delivery.Create().
+			SetUser(u).
+			SetArticle(a)
+	}
+
+	if _, err := client.Delivery.CreateBulk(builders...).Save(ctx); err != nil {
+		return fmt.Errorf("erreur lors de l'enregistrement des envois de %s: %v", u.Email, err)
+	}
+
+	return nil
+}
@@ -4,21 +4,25 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"tidy/ent"
+	"tidy/ent/cronjob"
 	"tidy/ent/scraper"
+	"tidy/ent/scraperun"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 type CronTask struct {
-	ID          int
-	Name        string
-	Time        string
-	ScraperID   int
-	ScraperName string
-	Status      string // "running", "stopped", "error"
-	LastRun     *time.Time
-	NextRun     *time.Time
-	EntryID     cron.EntryID
+	ID           int
+	Name         string
+	Time         string
+	CronJobID    int
+	ScraperIDs   []int
+	ScraperNames []string
+	Status       string // "running", "stopped", "error"
+	LastRun      *time.Time
+	NextRun      *time.Time
+	EntryID      cron.EntryID
 }
 type CronManager struct {
 	cron    *cron.Cron
@@ -41,17 +45,21 @@ func (cm *CronManager) AddTask(task *CronTask) error {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 
-	// Créer une fonction pour exécuter le scraper
+	// Créer une fonction pour exécuter les scrapers du cron job
 	scraperFunc := func() {
 		cm.updateTaskStatus(task.ID, "running")
 		now := time.Now()
 		task.LastRun = &now
 		
-		fmt.Printf("🕒 Exécution de la tâche '%s' pour le scraper '%s' à %s\n", 
-			task.Name, task.ScraperName, now.Format("2006-01-02 15:04:05"))
+		fmt.Printf("🕒 Exécution de la tâche '%s' pour les scrapers %v à %s\n", 
+			task.Name, task.ScraperNames, now.Format("2006-01-02 15:04:05"))
 		
-		// Exécuter le scraper
-		executeScraperByID(task.ScraperID)
+		// Exécuter les scrapers et envoyer le récapitulatif
+		if err := executeCronJobByID(task.CronJobID, scraperun.TriggerCron); err != nil {
+			fmt.Printf("❌ Erreur lors de l'exécution de la tâche '%s': %v\n", task.Name, err)
+			cm.updateTaskStatus(task.ID, "error")
+			return
+		}
 		
 		// Mettre à jour le statut après exécution
 		cm.updateTaskStatus(task.ID, "completed")
@@ -146,27 +154,174 @@ func (cm *CronManager) Stop() {
 	fmt.Println("⏹️ Gestionnaire de tâches cron arrêté")
 }
 
-// executeScraperByID exécute un scraper spécifique par son ID
-func executeScraperByID(scraperID int) {
+// executeScraperByID exécute un scraper spécifique par son ID et retourne les articles de sa page.
+// L'exécution est enregistrée dans un ScrapeRun, rattaché au cron job qui l'a lancée
+func executeScraperByID(ctx context.Context, client *ent.Client, scraperID int, job *ent.CronJob, trigger scraperun.Trigger) ([]*ent.Article, error) {
+	scraper, err := client.Scraper.Query().
+		Where(scraper.IDEQ(scraperID)).
+		WithSchema(). // si tu as besoin du schema
+		Only(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération du scraper %d: %v", scraperID, err)
+	}
+
+	run, err := startScrapeRun(ctx, client, scraper, job, trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Le contenu brut est archivé pour pouvoir rejouer l'extraction après une correction du schema
+	var outcome scrapeOutcome
+	var body []byte
+	body, outcome.HTTPStatus, outcome.Err = fetchSource(ctx, scraper)
+	outcome.Bytes = len(body)
+	if outcome.Err == nil {
+		if len(body) > 0 {
+			if outcome.Snapshot, err = saveSnapshot(ctx, client, body); err != nil {
+				fmt.Printf("❌ %v\n", err)
+			}
+		}
+		outcome.Items, outcome.Err = extractSource(scraper, body)
+	}
+
+	var articles []*ent.Article
+	if outcome.Err == nil {
+		articles, outcome.NewItems, outcome.Err = saveArticles(ctx, client, scraper, outcome.Items)
+	}
+
+	if _, err := recordScrapeRun(ctx, client, scraper, run, outcome); err != nil {
+		fmt.Printf("❌ %v\n", err)
+	}
+	if outcome.Err != nil {
+		return nil, outcome.Err
+	}
+	fmt.Printf("📰 %d nouveaux articles enregistrés pour le scraper %s (%d trouvés)\n", outcome.NewItems, scraper.Name, len(outcome.Items))
+
+	return articles, nil
+}
+
+// executeCronJobByID exécute tous les scrapers d'un cron job et envoie un récapitulatif unique à chaque abonné.
+// trigger indique si le cron job a été lancé par sa planification ou à la demande
+func executeCronJobByID(cronJobID int, trigger scraperun.Trigger) error {
 	client := getClient()
 	defer client.Close()
 
 	ctx := context.Background()
-	scraper, err := client.Scraper.Query().
-		Where(scraper.IDEQ(scraperID)).
-		WithSchema(). // si tu as besoin du schema
+	job, err := client.CronJob.Query().
+		Where(cronjob.IDEQ(cronJobID)).
+		WithNewsletter().
+		WithScrapers().
 		Only(ctx)
 	if err != nil {
-		fmt.Printf("❌ Erreur lors de la récupération du scraper %d: %v\n", scraperID, err)
-		return
+		return fmt.Errorf("erreur lors de la récupération du cron job %d: %v", cronJobID, err)
 	}
 
-	personalScraper(scraper)
+	// Exécution des scrapers en parallèle
+	results := make([]ScraperResult, len(job.Edges.Scrapers))
+	var wg sync.WaitGroup
+	for i, s := range job.Edges.Scrapers {
+		wg.Add(1)
+		go func(i int, s *ent.Scraper) {
+			defer wg.Done()
+			articles, err := executeScraperByID(ctx, client, s.ID, job, trigger)
+			if err != nil {
+				fmt.Printf("❌ Erreur lors de l'exécution du scraper '%s': %v\n", s.Name, err)
+			}
+			results[i] = ScraperResult{Scraper: s, Articles: articles, Err: err}
+		}(i, s)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if len(results) > 0 && failed == len(results) {
+		return fmt.Errorf("aucun des %d scrapers n'a pu être exécuté", failed)
+	}
+
+	merged := mergeResults(results)
+
+	// Seuls les abonnés actifs de la newsletter du cron job reçoivent le récapitulatif
+	subscriptions, err := activeSubscriptions(ctx, client, job.Edges.Newsletter.ID)
+	if err != nil {
+		return err
+	}
+
+	images := newImageCache()
+	for _, sub := range subscriptions {
+		u := sub.Edges.User
+		sections, pending, err := buildDigest(ctx, client, u, merged)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+
+		// Rien de nouveau pour cet utilisateur
+		if len(pending) == 0 {
+			fmt.Printf("📭 Aucun nouvel article pour %s\n", u.Email)
+			continue
+		}
+
+		link, err := unsubscribeURL(sub)
+		if err != nil {
+			fmt.Printf("❌ Impossible de générer le lien de désinscription de %s: %v\n", u.Email, err)
+			continue
+		}
+
+		if err := sendMail(ctx, client, job.Edges.Newsletter, u, link, sections, pending, images); err != nil {
+			fmt.Printf("❌ Erreur lors de la mise en file du récapitulatif de %s: %v\n", u.Email, err)
+		}
+	}
+
+	return nil
+}
+
+// pruneSnapshotsTask supprime les snapshots qui ne sont plus conservés
+func pruneSnapshotsTask() {
+	client := getClient()
+	defer client.Close()
+
+	if deleted, err := pruneSnapshots(context.Background(), client); err != nil {
+		fmt.Printf("❌ %v\n", err)
+	} else if deleted > 0 {
+		fmt.Printf("🗑️ %d anciens snapshots supprimés\n", deleted)
+	}
+}
+
+// failInterruptedRunsTask marque en échec les exécutions interrompues dont le bail a expiré
+func failInterruptedRunsTask() {
+	client := getClient()
+	defer client.Close()
+
+	if interrupted, err := failInterruptedRuns(context.Background(), client); err != nil {
+		fmt.Printf("❌ %v\n", err)
+	} else if interrupted > 0 {
+		fmt.Printf("⚠️ %d exécutions interrompues marquées en échec\n", interrupted)
+	}
 }
 
 func startCron() {
+	requireTokenSecret()
+
 	// Initialiser le gestionnaire de tâches
 	cronManager = NewCronManager()
+
+	// Les exécutions restées en cours lors d'un arrêt sont marquées en échec une fois leur bail expiré,
+	// vérifié au démarrage puis à chaque RUN_LEASE_MINUTES pour rattraper les redémarrages pendant le bail
+	failInterruptedRunsTask()
+	lease := intSetting("RUN_LEASE_MINUTES", 30)
+	if _, err := cronManager.cron.AddFunc(fmt.Sprintf("@every %dm", lease), failInterruptedRunsTask); err != nil {
+		fmt.Printf("❌ Erreur lors de l'ajout de la reprise des exécutions interrompues: %v\n", err)
+	}
+
+	// Les anciens snapshots sont supprimés au démarrage puis chaque nuit, pas à chaque exécution d'un cron job
+	pruneSnapshotsTask()
+	if _, err := cronManager.cron.AddFunc("@daily", pruneSnapshotsTask); err != nil {
+		fmt.Printf("❌ Erreur lors de l'ajout de la suppression des snapshots: %v\n", err)
+	}
  
 	// Récupérer les tâches depuis la base de données
 	jobs := getCronJobs()
@@ -175,25 +330,30 @@ func startCron() {
 	for _, job := range jobs {
 		fmt.Printf("📋 Configuration de la tâche: %s\n", job.Name)
 		
-		scrapers := job.Edges.Scrapers
-		for _, scraper := range scrapers {
-			// Créer une tâche pour chaque scraper
-			task := &CronTask{
-				ID:          job.ID, // Utiliser l'ID du job comme base
-				Name:        fmt.Sprintf("%s - %s", job.Name, scraper.Name),
-				Time:        job.Time,
-				ScraperID:   scraper.ID,
-				ScraperName: scraper.Name,
-				Status:      "stopped",
-			}
+		// Une seule tâche par cron job, qui exécute tous ses scrapers
+		scraperIDs := make([]int, 0, len(job.Edges.Scrapers))
+		scraperNames := make([]string, 0, len(job.Edges.Scrapers))
+		for _, scraper := range job.Edges.Scrapers {
+			scraperIDs = append(scraperIDs, scraper.ID)
+			scraperNames = append(scraperNames, scraper.Name)
+		}
 
-			// Ajouter la tâche au gestionnaire
-			if err := cronManager.AddTask(task); err != nil {
-				fmt.Printf("❌ Erreur lors de l'ajout de la tâche '%s': %v\n", task.Name, err)
-			} else {
-				fmt.Printf("✅ Tâche '%s' configurée pour le scraper '%s' à %s\n", 
-					task.Name, scraper.Name, job.Time)
-			}
+		task := &CronTask{
+			ID:           job.ID,
+			Name:         job.Name,
+			Time:         job.Time,
+			CronJobID:    job.ID,
+			ScraperIDs:   scraperIDs,
+			ScraperNames: scraperNames,
+			Status:       "stopped",
+		}
+
+		// Ajouter la tâche au gestionnaire
+		if err := cronManager.AddTask(task); err != nil {
+			fmt.Printf("❌ Erreur lors de l'ajout de la tâche '%s': %v\n", task.Name, err)
+		} else {
+			fmt.Printf("✅ Tâche '%s' configurée pour %d scrapers à %s\n", 
+				task.Name, len(scraperIDs), job.Time)
 		}
 	}
 
@@ -214,15 +374,16 @@ func GetCronTasksAPI() []map[string]interface{} {
 
 	for i, task := range tasks {
 		result[i] = map[string]interface{}{
-			"id":           task.ID,
-			"name":         task.Name,
-			"time":         task.Time,
-			"scraper_id":   task.ScraperID,
-			"scraper_name": task.ScraperName,
-			"status":       task.Status,
-			"last_run":     task.LastRun,
-			"next_run":     task.NextRun,
-			"entry_id":     task.EntryID,
+			"id":            task.ID,
+			"name":          task.Name,
+			"time":          task.Time,
+			"cronjob_id":    task.CronJobID,
+			"scraper_ids":   task.ScraperIDs,
+			"scraper_names": task.ScraperNames,
+			"status":        task.Status,
+			"last_run":      task.LastRun,
+			"next_run":      task.NextRun,
+			"entry_id":      task.EntryID,
 		}
 	}
 
@@ -240,14 +401,15 @@ func GetCronTaskAPI(taskID int) (map[string]interface{}, error) {
 	}
 
 	return map[string]interface{}{
-		"id":           task.ID,
-		"name":         task.Name,
-		"time":         task.Time,
-		"scraper_id":   task.ScraperID,
-		"scraper_name": task.ScraperName,
-		"status":       task.Status,
-		"last_run":     task.LastRun,
-		"next_run":     task.NextRun,
-		"entry_id":     task.EntryID,
+		"id":            task.ID,
+		"name":          task.Name,
+		"time":          task.Time,
+		"cronjob_id":    task.CronJobID,
+		"scraper_ids":   task.ScraperIDs,
+		"scraper_names": task.ScraperNames,
+		"status":        task.Status,
+		"last_run":      task.LastRun,
+		"next_run":      task.NextRun,
+		"entry_id":      task.EntryID,
 	}, nil
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"tidy/ent"
+	"tidy/ent/article"
+	"tidy/ent/cronjob"
+	"tidy/ent/newsletter"
+	"tidy/ent/scraper"
+)
+
+// DigestSection regroupe les articles d'un même site dans le mail récapitulatif
+type DigestSection struct {
+	Source   string
+	Site     string
+	Articles []*ent.Article
+}
+
+// ScraperResult contient les articles trouvés par un scraper lors de l'exécution d'un cron job
+type ScraperResult struct {
+	Scraper  *ent.Scraper
+	Articles []*ent.Article
+	Err      error
+}
+
+// siteName retourne le nom d'hôte d'un lien, ou le lien lui-même s'il est invalide
+func siteName(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	return u.Host
+}
+
+// mergeResults fusionne les résultats des scrapers en supprimant les articles présents plusieurs fois
+func mergeResults(results []ScraperResult) []ScraperResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Scraper.Name < results[j].Scraper.Name
+	})
+
+	seen := make(map[int]bool)
+	merged := make([]ScraperResult, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+
+		articles := make([]*ent.Article, 0, len(result.Articles))
+		for _, a := range result.Articles {
+			if seen[a.ID] {
+				continue
+			}
+			seen[a.ID] = true
+			articles = append(articles, a)
+		}
+
+		// Les articles les plus récents en premier
+		sort.SliceStable(articles, func(i, j int) bool {
+			return articles[i].FirstSeenAt.After(articles[j].FirstSeenAt)
+		})
+
+		merged = append(merged, ScraperResult{
+			Scraper:  result.Scraper,
+			Articles: articles,
+		})
+	}
+
+	return merged
+}
+
+// buildDigest construit les sections du mail d'un utilisateur avec les articles qu'il n'a pas encore reçus
+func buildDigest(ctx context.Context, client *ent.Client, u *ent.User, results []ScraperResult) ([]DigestSection, []*ent.Article, error) {
+	all := make([]*ent.Article, 0)
+	for _, result := range results {
+		all = append(all, result.Articles...)
+	}
+
+	pending, err := undeliveredArticles(ctx, client, u, all)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pendingIDs := make(map[int]bool, len(pending))
+	for _, a := range pending {
+		pendingIDs[a.ID] = true
+	}
+
+	sections := make([]DigestSection, 0, len(results))
+	for _, result := range results {
+		articles := make([]*ent.Article, 0)
+		for _, a := range result.Articles {
+			if pendingIDs[a.ID] {
+				articles = append(articles, a)
+			}
+		}
+		if len(articles) == 0 {
+			continue
+		}
+
+		sections = append(sections, DigestSection{
+			Source:   result.Scraper.Name,
+			Site:     siteName(result.Scraper.Link),
+			Articles: articles,
+		})
+	}
+
+	return sections, pending, nil
+}
+
+// latestSections retourne les derniers articles enregistrés par les scrapers d'une newsletter, pour la prévisualisation
+func latestSections(ctx context.Context, client *ent.Client, newsletterID int, limit int) ([]DigestSection, error) {
+	scrapers, err := client.Scraper.Query().
+		Where(scraper.HasCronjobsWith(cronjob.HasNewsletterWith(newsletter.IDEQ(newsletterID)))).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des scrapers de la newsletter %d: %v", newsletterID, err)
+	}
+
+	results := make([]ScraperResult, 0, len(scrapers))
+	for _, s := range scrapers {
+		articles, err := s.QueryArticles().
+			Order(ent.Desc(article.FieldFirstSeenAt), ent.Desc(article.FieldID)).
+			Limit(limit).
+			All(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("erreur lors de la récupération des articles du scraper %s: %v", s.Name, err)
+		}
+		results = append(results, ScraperResult{Scraper: s, Articles: articles})
+	}
+
+	sections := make([]DigestSection, 0, len(results))
+	for _, result := range mergeResults(results) {
+		if len(result.Articles) == 0 {
+			continue
+		}
+		sections = append(sections, DigestSection{
+			Source:   result.Scraper.Name,
+			Site:     siteName(result.Scraper.Link),
+			Articles: result.Articles,
+		})
+	}
+
+	return sections, nil
+}
+
+// digestSize retourne le nombre total d'articles d'un mail récapitulatif
+func digestSize(sections []DigestSection) int {
+	total := 0
+	for _, section := range sections {
+		total += len(section.Articles)
+	}
+	return total
+}
+
+// sectionAnchor retourne l'ancre HTML d'une section pour la table des matières
+func sectionAnchor(index int) string {
+	return fmt.Sprintf("source-%d", index+1)
+}
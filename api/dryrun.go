@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"tidy/ent"
+	"time"
+)
+
+// DryRunResult est le résultat du test d'un scraper : articles extraits, nombre de valeurs trouvées par champ et avertissements
+type DryRunResult struct {
+	Kind       string                   `json:"kind"`
+	Link       string                   `json:"link"`
+	DurationMs int64                    `json:"duration_ms"`
+	Count      int                      `json:"count"`
+	Matches    map[string]int           `json:"matches"`
+	Warnings   []string                 `json:"warnings"`
+	Items      []map[string]interface{} `json:"items"`
+}
+
+// dryRunScraper exécute un scraper sans enregistrer d'articles ni envoyer d'email
+func dryRunScraper(ctx context.Context, scraperDetails *ent.Scraper) (*DryRunResult, error) {
+	kind := scraperKind(scraperDetails)
+	start := time.Now()
+
+	items, err := scrapeSource(ctx, scraperDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DryRunResult{
+		Kind:       string(kind),
+		Link:       scraperDetails.Link,
+		DurationMs: time.Since(start).Milliseconds(),
+		Count:      len(items),
+		Items:      items,
+	}
+
+	fields := scraperFields(scraperDetails)
+	result.Matches = fieldMatches(items, fields)
+	result.Warnings = dryRunWarnings(items, fields, result.Matches)
+	return result, nil
+}
+
+// scraperFields retourne les champs extraits par un scraper, extra.<nom> pour les champs supplémentaires de son schema
+func scraperFields(scraperDetails *ent.Scraper) []string {
+	fields := append([]string{}, articleFields...)
+	if scraperDetails.Edges.Schema != nil {
+		for _, field := range scraperDetails.Edges.Schema.ExtraFields {
+			fields = append(fields, "extra."+field.Name)
+		}
+	}
+	return fields
+}
+
+// fieldMatches compte, pour chaque champ, les éléments où il n'est pas vide
+func fieldMatches(items []map[string]interface{}, fields []string) map[string]int {
+	matches := make(map[string]int, len(fields))
+	for _, field := range fields {
+		matches[field] = 0
+	}
+	for _, item := range items {
+		for _, field := range fields {
+			if itemValue(item, field) != "" {
+				matches[field]++
+			}
+		}
+	}
+	return matches
+}
+
+// itemValue retourne la valeur d'un champ d'article extrait, extra.<nom> pour les champs supplémentaires
+func itemValue(item map[string]interface{}, field string) string {
+	if name, ok := strings.CutPrefix(field, "extra."); ok {
+		extra, _ := item["extra"].(map[string]string)
+		return extra[name]
+	}
+	value, _ := item[field].(string)
+	return value
+}
+
+// dryRunWarnings signale les problèmes courants d'un schema : aucun conteneur, champs vides, liens invalides ou en double
+func dryRunWarnings(items []map[string]interface{}, fields []string, matches map[string]int) []string {
+	warnings := []string{}
+	if len(items) == 0 {
+		return append(warnings, "aucun élément trouvé : le sélecteur du conteneur ne correspond à rien")
+	}
+
+	for _, field := range fields {
+		switch count := matches[field]; {
+		case count == 0:
+			warnings = append(warnings, fmt.Sprintf("le champ %s est vide pour tous les éléments", field))
+		case count < len(items):
+			warnings = append(warnings, fmt.Sprintf("le champ %s est vide pour %d éléments sur %d", field, len(items)-count, len(items)))
+		}
+	}
+
+	// Les éléments sans lien ou avec un lien déjà vu sont ignorés à l'enregistrement
+	seen := make(map[string]bool, len(items))
+	duplicates := 0
+	for _, item := range items {
+		link := canonicalLink(itemValue(item, "link"))
+		if link == "" {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			warnings = append(warnings, fmt.Sprintf("lien invalide: %s", link))
+			continue
+		}
+		if seen[link] {
+			duplicates++
+		}
+		seen[link] = true
+	}
+	if duplicates > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d éléments ont un lien en double et ne seraient enregistrés qu'une fois", duplicates))
+	}
+
+	return warnings
+}
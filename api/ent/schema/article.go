@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+)
+
+// Article holds the schema definition for the Article entity.
+type Article struct {
+	ent.Schema
+}
+
+// Fields of the Article.
+func (Article) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("title"),
+		field.String("description"),
+		field.String("image"),
+		field.String("time"), // date telle qu'affichée sur le site
+		field.String("link").
+			NotEmpty().
+			Unique(), // lien canonique, sert de clé de déduplication
+		field.JSON("extra", map[string]string{}).
+			Optional(), // valeurs des champs supplémentaires déclarés par le schema du scraper
+		field.Time("first_seen_at").
+			Default(time.Now).
+			Immutable(),
+	}
+}
+
+// Edges of the Article.
+func (Article) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("scraper", Scraper.Type).
+			Ref("articles").
+			Unique(),
+		edge.To("deliveries", Delivery.Type),
+	}
+}
@@ -27,5 +27,6 @@ func (CronJob) Edges() []ent.Edge {
 			Ref("cronjobs").
 			Unique().
 			Required(),
+		edge.To("runs", ScrapeRun.Type),
 	}
 }
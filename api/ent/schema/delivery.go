@@ -0,0 +1,47 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Delivery holds the schema definition for the Delivery entity.
+type Delivery struct {
+	ent.Schema
+}
+
+// Fields of the Delivery.
+func (Delivery) Fields() []ent.Field {
+	return []ent.Field{
+		field.Time("sent_at").
+			Default(time.Now).
+			Immutable(),
+	}
+}
+
+// Edges of the Delivery.
+func (Delivery) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("user", User.Type).
+			Ref("deliveries").
+			Unique().
+			Required(),
+		edge.From("article", Article.Type).
+			Ref("deliveries").
+			Unique().
+			Required(),
+	}
+}
+
+// Indexes of the Delivery.
+func (Delivery) Indexes() []ent.Index {
+	return []ent.Index{
+		// Un article n'est envoyé qu'une seule fois à un utilisateur
+		index.Edges("user", "article").
+			Unique(),
+	}
+}
@@ -0,0 +1,18 @@
+package schema
+
+// FieldRule décrit comment extraire la valeur d'un champ d'article une fois son sélecteur appliqué
+type FieldRule struct {
+	Language  string `json:"language,omitempty"`  // langage du sélecteur : css (par défaut) ou xpath, relatif au conteneur (.//span)
+	Mode      string `json:"mode,omitempty"`      // text, html, ownText ou attr ; par défaut text, ou la détection des liens et images
+	Attribute string `json:"attribute,omitempty"` // attribut lu en mode attr (content, datetime, title, data-src...)
+	Regex     string `json:"regex,omitempty"`     // expression régulière appliquée à la valeur, le premier groupe capturant est conservé
+	Trim      bool   `json:"trim,omitempty"`      // supprime les espaces autour de la valeur et réduit les espaces internes
+}
+
+// ExtraField est un champ supplémentaire déclaré par un schema (auteur, catégorie, note...),
+// enregistré dans la map extra des articles
+type ExtraField struct {
+	Name     string `json:"name"`     // clé dans la map extra de l'article
+	Selector string `json:"selector"` // sélecteur CSS ou XPath selon Language, relatif au conteneur
+	FieldRule
+}
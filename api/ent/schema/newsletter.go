@@ -16,6 +16,17 @@ func (Newsletter) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("name").NotEmpty(),
 		field.String("description").NotEmpty(),
+		field.String("subject").
+			Optional(), // modèle text/template du sujet des emails, vide pour le sujet par défaut
+		field.String("template").
+			Default("default"), // nom du modèle d'email dans templates/digest
+		field.Text("html_template").
+			Optional(), // modèle html/template personnalisé, remplace le modèle par défaut
+		field.Text("text_template").
+			Optional(), // modèle text/template personnalisé, remplace le modèle par défaut
+		field.Enum("image_mode").
+			Values("remote", "inline", "proxy").
+			Default("remote"), // images distantes, jointes en cid: ou servies en miniature par l'API
 	}
 }
 
@@ -23,6 +34,7 @@ func (Newsletter) Fields() []ent.Field {
 func (Newsletter) Edges() []ent.Edge {
 	return []ent.Edge{
 		edge.To("cronjobs", CronJob.Type),
-		edge.To("users", User.Type),
+		edge.To("users", User.Type).
+			Through("subscriptions", Subscription.Type),
 	}
 }
\ No newline at end of file
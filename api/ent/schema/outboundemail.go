@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// OutboundEmail holds the schema definition for the OutboundEmail entity.
+type OutboundEmail struct {
+	ent.Schema
+}
+
+// Fields of the OutboundEmail.
+func (OutboundEmail) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("from"),
+		field.String("to").NotEmpty(),
+		field.String("subject"),
+		field.Bytes("message"), // message complet (en-têtes et corps) tel qu'envoyé au serveur SMTP
+		field.Enum("status").
+			Values("queued", "sending", "sent", "dead").
+			Default("queued"),
+		field.Int("attempts").
+			Default(0),
+		field.String("last_error").
+			Optional(),
+		field.Time("next_attempt_at").
+			Default(time.Now),
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(),
+		field.Time("claimed_at").
+			Optional().
+			Nillable(), // réservation par un worker, reprise par les autres après MAIL_CLAIM_LEASE_MINUTES
+		field.Time("sent_at").
+			Optional().
+			Nillable(),
+		field.Int("user_id").
+			Optional(), // abonné destinataire d'un récapitulatif, 0 pour les autres messages
+		field.Ints("article_ids").
+			Optional(), // articles du récapitulatif, marqués envoyés tant que le message n'est pas abandonné
+	}
+}
+
+// Edges of the OutboundEmail.
+func (OutboundEmail) Edges() []ent.Edge {
+	return nil
+}
+
+// Indexes of the OutboundEmail.
+func (OutboundEmail) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("status", "next_attempt_at"),
+	}
+}
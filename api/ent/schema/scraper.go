@@ -16,7 +16,24 @@ func (Scraper) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("name").NotEmpty(),
 		field.String("link").NotEmpty(),
-		field.Bool("premium"),
+		field.Enum("kind").
+			Values("html", "premium-html", "rss", "atom", "json-feed", "json").
+			Default("html"), // page HTML (navigateur headless pour premium-html), flux ou API JSON
+		field.Bool("premium").
+			Default(false), // ancien indicateur, équivalent à kind premium-html
+		field.JSON("headers", map[string]string{}).
+			Optional().
+			Sensitive(), // en-têtes HTTP ajoutés à la requête (Authorization, Accept...), jamais renvoyés par l'API
+		field.JSON("query", map[string]string{}).
+			Optional(), // paramètres ajoutés à l'URL de la requête
+		field.Bool("degraded").
+			Default(false), // moins d'éléments trouvés que d'habitude, le schema est sans doute cassé
+		field.Time("degraded_since").
+			Optional().
+			Nillable(),
+		field.Time("baseline_since").
+			Optional().
+			Nillable(), // seules les exécutions depuis cette date servent de référence, après un changement durable du site
 	}
 }
 
@@ -24,9 +41,10 @@ func (Scraper) Fields() []ent.Field {
 func (Scraper) Edges() []ent.Edge {
 	return []ent.Edge{
 		edge.To("schema", ScraperSchema.Type).
-			Unique().
-			Required(),
+			Unique(), // requis pour les pages HTML, inutile pour les flux
 		edge.From("cronjobs", CronJob.Type).
 			Ref("scrapers"),
+		edge.To("articles", Article.Type),
+		edge.To("runs", ScrapeRun.Type),
 	}
 }
\ No newline at end of file
@@ -13,12 +13,19 @@ type ScraperSchema struct {
 // Fields of the ScraperSchema.
 func (ScraperSchema) Fields() []ent.Field {
 	return []ent.Field{
+		field.Enum("format").
+			Values("html", "json").
+			Default("html"), // sélecteurs CSS/XPath pour les pages, chemins gjson pour les API JSON
 		field.String("container").NotEmpty(),
 		field.String("title").NotEmpty(),
 		field.String("description").NotEmpty(),
 		field.String("image").NotEmpty(),
 		field.String("time").NotEmpty(),
 		field.String("link").NotEmpty(),
+		field.JSON("rules", map[string]FieldRule{}).
+			Optional(), // règles d'extraction par champ (title, description, image, time, link)
+		field.JSON("extra_fields", []ExtraField{}).
+			Optional(), // champs supplémentaires enregistrés dans Article.extra
 	}
 }
 
@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// ScrapeRun holds the schema definition for the ScrapeRun entity.
+type ScrapeRun struct {
+	ent.Schema
+}
+
+// Fields of the ScrapeRun.
+func (ScrapeRun) Fields() []ent.Field {
+	return []ent.Field{
+		field.Enum("status").
+			Values("running", "ok", "degraded", "failed").
+			Default("running"),
+		field.Enum("trigger").
+			Values("cron", "manual").
+			Default("cron"),
+		field.Int("http_status").
+			Optional(), // statut de la réponse, absent pour les pages chargées par le navigateur headless
+		field.Int("bytes").
+			Default(0), // taille du contenu récupéré
+		field.Int("items").
+			Default(0), // nombre de conteneurs trouvés
+		field.Int("new_items").
+			Default(0), // articles enregistrés pour la première fois
+		field.JSON("empty_fields", map[string]int{}).
+			Optional(), // nombre d'éléments où chaque champ est vide, extra.<nom> pour les champs supplémentaires
+		field.Float("baseline").
+			Optional(), // moyenne des éléments trouvés par les exécutions ok précédentes
+		field.JSON("reasons", []string{}).
+			Optional(), // pourquoi l'exécution est dégradée
+		field.String("error").
+			Optional(),
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(), // début de l'exécution
+		field.Time("finished_at").
+			Optional().
+			Nillable(),
+	}
+}
+
+// Edges of the ScrapeRun.
+func (ScrapeRun) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("scraper", Scraper.Type).
+			Ref("runs").
+			Unique().
+			Required(),
+		edge.From("cronjob", CronJob.Type).
+			Ref("runs").
+			Unique(), // absent si le cron job a été supprimé
+		edge.From("snapshot", Snapshot.Type).
+			Ref("runs").
+			Unique(), // contenu brut récupéré, absent si la récupération a échoué
+	}
+}
+
+// Indexes of the ScrapeRun.
+func (ScrapeRun) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Edges("scraper").
+			Fields("created_at"),
+	}
+}
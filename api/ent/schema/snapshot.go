@@ -0,0 +1,38 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+)
+
+// Snapshot holds the schema definition for the Snapshot entity.
+type Snapshot struct {
+	ent.Schema
+}
+
+// Fields of the Snapshot.
+func (Snapshot) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("hash").
+			NotEmpty().
+			Unique().
+			Immutable(), // sha256 du contenu brut, une page identique n'est stockée qu'une fois
+		field.Bytes("data").
+			Immutable(), // contenu compressé en gzip
+		field.Int("size").
+			Immutable(), // taille du contenu avant compression
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(),
+	}
+}
+
+// Edges of the Snapshot.
+func (Snapshot) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.To("runs", ScrapeRun.Type),
+	}
+}
@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Subscription holds the schema definition for the Subscription entity.
+type Subscription struct {
+	ent.Schema
+}
+
+// Fields of the Subscription.
+func (Subscription) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("user_id"),
+		field.Int("newsletter_id"),
+		field.Enum("status").
+			Values("pending", "active", "unsubscribed").
+			Default("pending"), // "pending" jusqu'à la confirmation par email (double opt-in)
+		field.Time("created_at").
+			Default(time.Now).
+			Immutable(),
+		field.JSON("preferences", map[string]interface{}{}).
+			Optional(),
+		field.Time("confirmation_sent_at").
+			Optional().
+			Nillable(), // dernier envoi du lien de confirmation, pour limiter les renvois
+	}
+}
+
+// Edges of the Subscription.
+func (Subscription) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.To("user", User.Type).
+			Unique().
+			Required().
+			Field("user_id"),
+		edge.To("newsletter", Newsletter.Type).
+			Unique().
+			Required().
+			Field("newsletter_id"),
+	}
+}
+
+// Indexes of the Subscription.
+func (Subscription) Indexes() []ent.Index {
+	return []ent.Index{
+		// Un utilisateur ne peut s'abonner qu'une fois à une newsletter
+		index.Fields("user_id", "newsletter_id").
+			Unique(),
+	}
+}
@@ -14,16 +14,16 @@ type User struct {
 // Fields of the User.
 func (User) Fields() []ent.Field {
 	return []ent.Field{
-		field.String("email").NotEmpty(),
+		field.String("email").NotEmpty().Unique(),
 	}
 }
 
 // Edges of the User.
 func (User) Edges() []ent.Edge {
 	return []ent.Edge{
-		edge.From("newsletter", Newsletter.Type).
+		edge.From("newsletters", Newsletter.Type).
 			Ref("users").
-			Unique().
-			Required(),
+			Through("subscriptions", Subscription.Type),
+		edge.To("deliveries", Delivery.Type),
 	}
 }
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"tidy/ent"
+	"tidy/ent/schema"
+	"tidy/ent/scraperschema"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// Modes d'extraction d'une règle de champ
+const (
+	modeText    = "text"
+	modeHTML    = "html"
+	modeOwnText = "ownText"
+	modeAttr    = "attr"
+)
+
+// Langages des sélecteurs
+const (
+	selectorCSS   = "css"
+	selectorXPath = "xpath"
+)
+
+// articleFields sont les champs d'un article qui acceptent une règle d'extraction
+var articleFields = []string{"title", "description", "image", "time", "link"}
+
+// validateScraperSchema vérifie les sélecteurs, les règles et les champs supplémentaires d'un schema
+func validateScraperSchema(scraperSchema *ent.ScraperSchema) error {
+	if err := validateFieldRules(scraperSchema.Rules); err != nil {
+		return err
+	}
+	if err := validateExtraFields(scraperSchema.ExtraFields); err != nil {
+		return err
+	}
+
+	// Les chemins gjson ne se compilent pas, seules l'expression régulière et le nettoyage s'appliquent
+	if scraperSchema.Format == scraperschema.FormatJSON {
+		return validateJSONRules(scraperSchema)
+	}
+
+	selectors := map[string]string{
+		"container":   scraperSchema.Container,
+		"title":       scraperSchema.Title,
+		"description": scraperSchema.Description,
+		"image":       scraperSchema.Image,
+		"time":        scraperSchema.Time,
+		"link":        scraperSchema.Link,
+	}
+	for name, selector := range selectors {
+		if err := validateSelector(name, selector, scraperSchema.Rules[name]); err != nil {
+			return err
+		}
+	}
+	for _, field := range scraperSchema.ExtraFields {
+		if err := validateSelector(field.Name, field.Selector, field.FieldRule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// absoluteXPath repère un chemin absolu ailleurs qu'au début d'une expression : (//a)[1], .//a | //b
+var absoluteXPath = regexp.MustCompile(`[|(]\s*/`)
+
+// relativeXPath rend relative au conteneur l'expression XPath d'un champ : //span devient .//span.
+// Sans le point, l'expression partirait de la racine du document et chaque article aurait les valeurs du premier
+func relativeXPath(selector string) string {
+	selector = strings.TrimSpace(selector)
+	if strings.HasPrefix(selector, "/") {
+		return "." + selector
+	}
+	return selector
+}
+
+// validateSelector vérifie qu'un sélecteur se compile dans le langage de sa règle
+func validateSelector(name string, selector string, rule schema.FieldRule) error {
+	switch selectorLanguage(rule) {
+	case selectorCSS:
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("sélecteur CSS invalide pour le champ %s: %v", name, err)
+		}
+	case selectorXPath:
+		if _, err := xpath.Compile(selector); err != nil {
+			return fmt.Errorf("expression XPath invalide pour le champ %s: %v", name, err)
+		}
+		// Seul le conteneur est cherché dans tout le document, un chemin absolu en début d'expression est rendu relatif
+		if name != "container" && absoluteXPath.MatchString(relativeXPath(selector)) {
+			return fmt.Errorf("l'expression XPath du champ %s doit être relative au conteneur (commencer par .): %s", name, selector)
+		}
+	default:
+		return fmt.Errorf("langage de sélecteur inconnu pour le champ %s: %s (css ou xpath)", name, rule.Language)
+	}
+	return nil
+}
+
+// selectorLanguage retourne le langage du sélecteur d'une règle, css par défaut
+func selectorLanguage(rule schema.FieldRule) string {
+	if rule.Language == "" {
+		return selectorCSS
+	}
+	return rule.Language
+}
+
+// selectNodes applique un sélecteur CSS ou XPath à partir de chaque élément de la sélection.
+// Les expressions XPath peuvent remonter ou parcourir les voisins (following-sibling::, ..).
+func selectNodes(s *goquery.Selection, selector string, rule schema.FieldRule) *goquery.Selection {
+	if selectorLanguage(rule) != selectorXPath {
+		return s.Find(selector)
+	}
+
+	expr, err := xpath.Compile(selector)
+	if err != nil {
+		return s.Slice(0, 0)
+	}
+
+	var nodes []*html.Node
+	for _, node := range s.Nodes {
+		nodes = append(nodes, htmlquery.QuerySelectorAll(node, expr)...)
+	}
+	// Sélection vide sans tableau partagé : AddNodes sur s.Slice(0, 0) écraserait les nœuds de s
+	empty := s.FilterFunction(func(int, *goquery.Selection) bool { return false })
+	return empty.AddNodes(nodes...)
+}
+
+// selectFieldNodes applique le sélecteur d'un champ à partir de son conteneur, une expression XPath absolue
+// étant rendue relative au conteneur
+func selectFieldNodes(s *goquery.Selection, selector string, rule schema.FieldRule) *goquery.Selection {
+	if selectorLanguage(rule) == selectorXPath {
+		selector = relativeXPath(selector)
+	}
+	return selectNodes(s, selector, rule)
+}
+
+// extraFieldName limite les noms de champs supplémentaires à des clés utilisables dans les modèles et les filtres
+var extraFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// validateFieldRules vérifie les règles d'extraction d'un schema avant de les enregistrer
+func validateFieldRules(rules map[string]schema.FieldRule) error {
+	for name, rule := range rules {
+		known := false
+		for _, field := range articleFields {
+			if field == name {
+				known = true
+				break
+			}
+		}
+		// Le conteneur n'accepte que le langage de son sélecteur
+		if name == "container" {
+			if rule.Mode != "" || rule.Attribute != "" || rule.Regex != "" || rule.Trim {
+				return fmt.Errorf("la règle du conteneur ne peut définir que son langage")
+			}
+			continue
+		}
+		if !known {
+			return fmt.Errorf("champ inconnu dans les règles: %s (disponibles: container, %s)", name, strings.Join(articleFields, ", "))
+		}
+
+		if err := validateFieldRule(name, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateExtraFields vérifie les champs supplémentaires d'un schema avant de les enregistrer
+func validateExtraFields(fields []schema.ExtraField) error {
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !extraFieldName.MatchString(field.Name) {
+			return fmt.Errorf("nom de champ supplémentaire invalide: %q (minuscules, chiffres et _)", field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("champ supplémentaire en double: %s", field.Name)
+		}
+		seen[field.Name] = true
+
+		if field.Selector == "" {
+			return fmt.Errorf("le champ supplémentaire %s n'a pas de sélecteur", field.Name)
+		}
+		if err := validateFieldRule(field.Name, field.FieldRule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFieldRule vérifie le mode, l'attribut et l'expression régulière d'une règle
+func validateFieldRule(name string, rule schema.FieldRule) error {
+	switch ruleMode(rule) {
+	case "", modeText, modeHTML, modeOwnText:
+	case modeAttr:
+		if rule.Attribute == "" {
+			return fmt.Errorf("le champ %s est en mode attr mais n'a pas d'attribut", name)
+		}
+	default:
+		return fmt.Errorf("mode d'extraction inconnu pour le champ %s: %s", name, rule.Mode)
+	}
+
+	if rule.Regex != "" {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("expression régulière invalide pour le champ %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ruleMode retourne le mode d'une règle, un attribut seul implique le mode attr
+func ruleMode(rule schema.FieldRule) string {
+	if rule.Mode == "" && rule.Attribute != "" {
+		return modeAttr
+	}
+	return rule.Mode
+}
+
+// extractArticle extrait les champs d'un article d'un conteneur selon les sélecteurs et les règles du schema
+func extractArticle(s *goquery.Selection, scraperSchema *ent.ScraperSchema, base *url.URL) map[string]interface{} {
+	selectors := map[string]string{
+		"title":       scraperSchema.Title,
+		"description": scraperSchema.Description,
+		"image":       scraperSchema.Image,
+		"time":        scraperSchema.Time,
+		"link":        scraperSchema.Link,
+	}
+
+	data := make(map[string]interface{}, len(selectors)+1)
+	for name, selector := range selectors {
+		rule := scraperSchema.Rules[name]
+		data[name] = extractField(selectFieldNodes(s, selector, rule), name, rule, base)
+	}
+
+	// Les champs supplémentaires vides ne sont pas enregistrés
+	extra := make(map[string]string, len(scraperSchema.ExtraFields))
+	for _, field := range scraperSchema.ExtraFields {
+		selection := selectFieldNodes(s, field.Selector, field.FieldRule)
+		if value := extractField(selection, field.Name, field.FieldRule, base); value != "" {
+			extra[field.Name] = value
+		}
+	}
+	data["extra"] = extra
+
+	return data
+}
+
+// extractField applique une règle à la sélection d'un champ.
+// Sans mode, les champs image et link utilisent la détection des attributs d'images et de liens.
+func extractField(s *goquery.Selection, name string, rule schema.FieldRule, base *url.URL) string {
+	var value string
+	switch ruleMode(rule) {
+	case modeHTML:
+		value, _ = s.First().Html()
+	case modeOwnText:
+		value = ownText(s.First())
+	case modeAttr:
+		value, _ = s.First().Attr(rule.Attribute)
+	case modeText:
+		value = s.Text()
+	default:
+		switch name {
+		case "image":
+			value = imageURL(s.First(), base)
+		case "link":
+			value = linkURL(s.First(), base)
+		default:
+			value = s.Text()
+		}
+
+		// Une expression XPath peut viser directement l'attribut (//a/@href), dont la valeur est le texte
+		if value == "" && (name == "image" || name == "link") && selectorLanguage(rule) == selectorXPath {
+			value = resolveURL(base, s.First().Text())
+		}
+	}
+
+	value = applyRule(value, rule)
+
+	// Les valeurs lues explicitement pour les liens et images sont résolues comme les autres
+	isURL := name == "image" || name == "link"
+	explicit := rule.Mode != "" || rule.Attribute != "" || rule.Regex != ""
+	if isURL && explicit {
+		value = resolveURL(base, value)
+	}
+
+	return value
+}
+
+// applyRule applique l'expression régulière et le nettoyage des espaces d'une règle à une valeur extraite
+func applyRule(value string, rule schema.FieldRule) string {
+	if rule.Regex != "" {
+		value = applyRegex(rule.Regex, value)
+	}
+	if rule.Trim {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	return value
+}
+
+// ownText retourne uniquement le texte propre d'un élément, sans celui de ses enfants
+func ownText(s *goquery.Selection) string {
+	var text strings.Builder
+	s.Contents().Each(func(i int, child *goquery.Selection) {
+		if goquery.NodeName(child) == "#text" {
+			text.WriteString(child.Text())
+		}
+	})
+	return text.String()
+}
+
+// applyRegex retourne le premier groupe capturant de l'expression, ou la correspondance complète sans groupe
+func applyRegex(expr string, value string) string {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ""
+	}
+
+	match := re.FindStringSubmatch(value)
+	if match == nil {
+		return ""
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
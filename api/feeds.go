@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// rssFeed est la structure d'un flux RSS 2.0
+type rssFeed struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        string       `xml:"guid"`
+	Description string       `xml:"description"`
+	Content     string       `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string       `xml:"pubDate"`
+	Date        string       `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Enclosures  []feedMedia  `xml:"enclosure"`
+	Media       []feedMedia  `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails  []feedMedia  `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Categories  []string     `xml:"category"`
+	Author      string       `xml:"author"`
+	Creator     string       `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Groups      []mediaGroup `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// feedMedia couvre <enclosure>, <media:content> et <media:thumbnail>
+type feedMedia struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+type mediaGroup struct {
+	Media      []feedMedia `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []feedMedia `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// atomFeed est la structure d'un flux Atom
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string       `xml:"title"`
+	Links      []atomLink   `xml:"link"`
+	ID         string       `xml:"id"`
+	Summary    string       `xml:"summary"`
+	Content    string       `xml:"content"`
+	Published  string       `xml:"published"`
+	Updated    string       `xml:"updated"`
+	Authors    []string     `xml:"author>name"`
+	Categories []atomTerm   `xml:"category"`
+	Thumbnails []feedMedia  `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Groups     []mediaGroup `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+// jsonFeed est la structure d'un flux JSON Feed 1.x
+type jsonFeed struct {
+	Items []struct {
+		ID            string   `json:"id"`
+		URL           string   `json:"url"`
+		Title         string   `json:"title"`
+		Summary       string   `json:"summary"`
+		ContentText   string   `json:"content_text"`
+		ContentHTML   string   `json:"content_html"`
+		Image         string   `json:"image"`
+		BannerImage   string   `json:"banner_image"`
+		DatePublished string   `json:"date_published"`
+		DateModified  string   `json:"date_modified"`
+		Tags          []string `json:"tags"`
+		Authors       []struct {
+			Name string `json:"name"`
+		} `json:"authors"`
+	} `json:"items"`
+}
+
+// feedDateLayouts sont les formats de date rencontrés dans les flux
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseFeed convertit un flux RSS, Atom ou JSON Feed en articles au même format que extractHTML
+func parseFeed(kind string, body []byte, feedURL string) ([]map[string]interface{}, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("URL de flux invalide %s: %v", feedURL, err)
+	}
+
+	switch kind {
+	case "rss":
+		return parseRSS(body, base)
+	case "atom":
+		return parseAtom(body, base)
+	case "json-feed":
+		return parseJSONFeed(body, base)
+	}
+	return nil, fmt.Errorf("type de flux inconnu: %s", kind)
+}
+
+func parseRSS(body []byte, base *url.URL) ([]map[string]interface{}, error) {
+	var feed rssFeed
+	if err := decodeXML(body, &feed); err != nil {
+		return nil, fmt.Errorf("flux RSS invalide: %v", err)
+	}
+
+	items := make([]map[string]interface{}, 0, len(feed.Channel.Items))
+	for _, item := range feed.Channel.Items {
+		link := item.Link
+		if link == "" {
+			// Un guid permalink sert de lien quand <link> est absent
+			link = item.GUID
+		}
+
+		description := item.Description
+		if description == "" {
+			description = item.Content
+		}
+
+		media := append(append([]feedMedia{}, item.Thumbnails...), item.Media...)
+		for _, group := range item.Groups {
+			media = append(append(media, group.Thumbnails...), group.Media...)
+		}
+		media = append(media, item.Enclosures...)
+
+		author := item.Creator
+		if author == "" {
+			author = item.Author
+		}
+
+		items = append(items, feedArticle(base, item.Title, link, description, feedImage(media, description, base), firstNonEmpty(item.PubDate, item.Date), map[string]string{
+			"author":   author,
+			"category": strings.Join(item.Categories, ", "),
+		}))
+	}
+	return items, nil
+}
+
+func parseAtom(body []byte, base *url.URL) ([]map[string]interface{}, error) {
+	var feed atomFeed
+	if err := decodeXML(body, &feed); err != nil {
+		return nil, fmt.Errorf("flux Atom invalide: %v", err)
+	}
+
+	items := make([]map[string]interface{}, 0, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		// Lien alternate en priorité, le premier lien sinon
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		if link == "" && len(entry.Links) > 0 {
+			link = entry.Links[0].Href
+		}
+
+		var media []feedMedia
+		media = append(media, entry.Thumbnails...)
+		for _, group := range entry.Groups {
+			media = append(append(media, group.Thumbnails...), group.Media...)
+		}
+		for _, l := range entry.Links {
+			if l.Rel == "enclosure" {
+				media = append(media, feedMedia{URL: l.Href, Type: l.Type})
+			}
+		}
+
+		description := firstNonEmpty(entry.Summary, entry.Content)
+
+		categories := make([]string, 0, len(entry.Categories))
+		for _, category := range entry.Categories {
+			categories = append(categories, category.Term)
+		}
+
+		items = append(items, feedArticle(base, entry.Title, link, description, feedImage(media, description, base), firstNonEmpty(entry.Published, entry.Updated), map[string]string{
+			"author":   strings.Join(entry.Authors, ", "),
+			"category": strings.Join(categories, ", "),
+		}))
+	}
+	return items, nil
+}
+
+func parseJSONFeed(body []byte, base *url.URL) ([]map[string]interface{}, error) {
+	var feed jsonFeed
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("flux JSON Feed invalide: %v", err)
+	}
+
+	items := make([]map[string]interface{}, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		// L'id d'un item est souvent son URL
+		link := firstNonEmpty(item.URL, item.ID)
+		description := firstNonEmpty(item.Summary, item.ContentText, item.ContentHTML)
+		image := firstNonEmpty(item.Image, item.BannerImage)
+		if image == "" {
+			image = feedImage(nil, item.ContentHTML, base)
+		}
+
+		authors := make([]string, 0, len(item.Authors))
+		for _, author := range item.Authors {
+			authors = append(authors, author.Name)
+		}
+
+		items = append(items, feedArticle(base, item.Title, link, description, image, firstNonEmpty(item.DatePublished, item.DateModified), map[string]string{
+			"author":   strings.Join(authors, ", "),
+			"category": strings.Join(item.Tags, ", "),
+		}))
+	}
+	return items, nil
+}
+
+// decodeXML décode un flux XML en acceptant les encodages déclarés autres que UTF-8 (ISO-8859-1...)
+func decodeXML(body []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	return decoder.Decode(v)
+}
+
+// feedArticle construit un article au format de extractHTML : liens absolus, texte sans HTML, date lisible
+func feedArticle(base *url.URL, title string, link string, description string, image string, date string, extra map[string]string) map[string]interface{} {
+	fields := make(map[string]string, len(extra))
+	for name, value := range extra {
+		if value = strings.TrimSpace(value); value != "" {
+			fields[name] = value
+		}
+	}
+
+	return map[string]interface{}{
+		"title":       htmlText(title),
+		"description": htmlText(description),
+		"image":       resolveURL(base, image),
+		"time":        feedDate(date),
+		"link":        resolveURL(base, link),
+		"extra":       fields,
+	}
+}
+
+// feedImage choisit la première image des médias du flux, ou la première <img> de la description HTML
+func feedImage(media []feedMedia, description string, base *url.URL) string {
+	for _, m := range media {
+		if m.URL == "" {
+			continue
+		}
+		if m.Type == "" || strings.HasPrefix(m.Type, "image/") || m.Medium == "image" {
+			return m.URL
+		}
+	}
+
+	if !strings.Contains(description, "<img") {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(description))
+	if err != nil {
+		return ""
+	}
+	return imageURL(doc.Find("img").First(), base)
+}
+
+// htmlText retire les balises HTML d'un titre ou d'une description de flux
+func htmlText(value string) string {
+	value = strings.TrimSpace(value)
+	if !strings.ContainsAny(value, "<&") {
+		return value
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(value))
+	if err != nil {
+		return value
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}
+
+// feedDate affiche une date de flux comme sur les sites scrapés, la valeur brute si elle n'est pas reconnue
+func feedDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Local().Format("02/01/2006, 15:04")
+		}
+	}
+	return value
+}
+
+// firstNonEmpty retourne la première valeur non vide
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
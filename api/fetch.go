@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// defaultUserAgent est envoyé par le fetcher et le navigateur headless quand FETCH_USER_AGENT n'est pas défini
+const defaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// fetchRequest décrit une requête GET du fetcher partagé par les scrapers et les miniatures
+type fetchRequest struct {
+	URL       string
+	Accept    string            // en-tête Accept, celui du navigateur par défaut
+	Headers   map[string]string // en-têtes ajoutés ou remplacés (Authorization, User-Agent...)
+	Query     map[string]string // paramètres ajoutés à l'URL
+	MaxBytes  int64             // taille maximale de la réponse, FETCH_MAX_BYTES par défaut
+	Transcode bool              // convertir une page HTML en UTF-8 selon son Content-Type, son BOM ou sa balise meta
+}
+
+// fetchResponse est une réponse complète du fetcher
+type fetchResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// fetcherClient n'a pas de timeout global, chaque requête a le sien via son contexte
+var fetcherClient = &http.Client{}
+
+// userAgent retourne le User-Agent des requêtes, configurable par FETCH_USER_AGENT
+func userAgent() string {
+	if ua := os.Getenv("FETCH_USER_AGENT"); ua != "" {
+		return ua
+	}
+	return defaultUserAgent
+}
+
+// fetchTimeout retourne la durée maximale d'une requête, FETCH_TIMEOUT_SECONDS (30s par défaut)
+func fetchTimeout() time.Duration {
+	return time.Duration(intSetting("FETCH_TIMEOUT_SECONDS", 30)) * time.Second
+}
+
+// checkSourceLink vérifie qu'un lien peut être récupéré par le fetcher : une URL http ou https avec un hôte
+func checkSourceLink(link string) error {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL invalide: %s", link)
+	}
+	return nil
+}
+
+// fetchURL télécharge une URL et retourne son contenu, ou une erreur si la requête échoue, si le statut n'est pas 2xx
+// ou si la réponse dépasse la taille maximale. Le statut est renseigné dès que le serveur a répondu
+func fetchURL(ctx context.Context, request fetchRequest) (*fetchResponse, error) {
+	u, err := url.Parse(request.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("URL invalide: %s", request.URL)
+	}
+
+	if len(request.Query) > 0 {
+		query := u.Query()
+		for key, value := range request.Query {
+			query.Set(key, value)
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("requête invalide pour %s: %v", u.Redacted(), err)
+	}
+
+	accept := request.Accept
+	if accept == "" {
+		accept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", userAgent())
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := fetcherClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la requête vers %s: %v", u.Redacted(), err)
+	}
+	defer res.Body.Close()
+
+	response := &fetchResponse{
+		Status:      res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return response, fmt.Errorf("%s a répondu avec le statut %d", u.Redacted(), res.StatusCode)
+	}
+
+	maxBytes := request.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = int64(intSetting("FETCH_MAX_BYTES", 10*1024*1024))
+	}
+	if res.ContentLength > maxBytes {
+		return response, fmt.Errorf("réponse de %s trop volumineuse (%d octets)", u.Redacted(), res.ContentLength)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	if err != nil {
+		return response, fmt.Errorf("erreur lors de la lecture de la réponse de %s: %v", u.Redacted(), err)
+	}
+	if int64(len(body)) > maxBytes {
+		return response, fmt.Errorf("réponse de %s trop volumineuse (plus de %d octets)", u.Redacted(), maxBytes)
+	}
+
+	if request.Transcode {
+		if body, err = toUTF8(body, response.ContentType); err != nil {
+			return response, fmt.Errorf("encodage de %s non supporté: %v", u.Redacted(), err)
+		}
+	}
+
+	response.Body = body
+	return response, nil
+}
+
+// toUTF8 convertit une page HTML en UTF-8, l'encodage est déterminé comme le ferait un navigateur.
+// Sans Content-Type, BOM ni balise meta, l'encodage deviné (windows-1252) n'est utilisé que si la page n'est pas de l'UTF-8 valide
+func toUTF8(body []byte, contentType string) ([]byte, error) {
+	encoding, name, certain := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" || (!certain && utf8.Valid(body)) {
+		return body, nil
+	}
+	return encoding.NewDecoder().Bytes(body)
+}
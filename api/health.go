@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"tidy/ent"
+	"tidy/ent/scraper"
+	"tidy/ent/scraperun"
+	"time"
+)
+
+// recordScrapeRun termine une exécution de scraper avec son résultat et compare le nombre d'éléments trouvés
+// à celui des exécutions précédentes. Le scraper est marqué dégradé, avec une alerte à ADMIN_EMAIL, quand il passe sous sa moyenne.
+// Une alerte est aussi envoyée après HEALTH_FAILURE_ALERT_RUNS échecs consécutifs
+func recordScrapeRun(ctx context.Context, client *ent.Client, s *ent.Scraper, run *ent.ScrapeRun, outcome scrapeOutcome) (*ent.ScrapeRun, error) {
+	items := outcome.Items
+	fields := scraperFields(s)
+	matches := fieldMatches(items, fields)
+	empty := make(map[string]int, len(fields))
+	for _, field := range fields {
+		empty[field] = len(items) - matches[field]
+	}
+
+	// Moyenne des dernières exécutions ok depuis la dernière nouvelle référence : une exécution dégradée ne fait pas baisser la référence.
+	// Quand le site a durablement changé (HEALTH_REBASELINE_RUNS exécutions dégradées de suite), ces exécutions deviennent la référence
+	streak := intSetting("HEALTH_REBASELINE_RUNS", 3)
+	previous, err := lastRuns(ctx, client, s, streak, scraperun.StatusOk, scraperun.StatusDegraded)
+	if err != nil {
+		return nil, err
+	}
+	rebaseline := len(previous) == streak && consecutive(previous, scraperun.StatusDegraded) == streak
+	if !rebaseline {
+		query := client.ScrapeRun.Query().
+			Where(
+				scraperun.HasScraperWith(scraper.IDEQ(s.ID)),
+				scraperun.StatusEQ(scraperun.StatusOk),
+			)
+		if s.BaselineSince != nil {
+			query.Where(scraperun.CreatedAtGTE(*s.BaselineSince))
+		}
+		previous, err = query.
+			Order(ent.Desc(scraperun.FieldCreatedAt)).
+			Limit(intSetting("HEALTH_BASELINE_RUNS", 5)).
+			All(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("erreur lors de la récupération des exécutions du scraper %s: %v", s.Name, err)
+		}
+	}
+
+	// Une seule alerte par série d'échecs : quand cette exécution est la N-ième de suite
+	failureAlert := false
+	failures := intSetting("HEALTH_FAILURE_ALERT_RUNS", 3)
+	if outcome.Err != nil {
+		runs, err := lastRuns(ctx, client, s, failures, scraperun.StatusOk, scraperun.StatusDegraded, scraperun.StatusFailed)
+		if err != nil {
+			return nil, err
+		}
+		failureAlert = consecutive(runs, scraperun.StatusFailed) == failures-1
+	}
+
+	status := scraperun.StatusOk
+	var baseline float64
+	var reasons []string
+	if outcome.Err != nil {
+		status = scraperun.StatusFailed
+	} else {
+		baseline, reasons = healthReasons(previous, len(items), fields, matches)
+		if len(reasons) > 0 {
+			status = scraperun.StatusDegraded
+		}
+	}
+
+	update := client.ScrapeRun.UpdateOne(run).
+		SetStatus(status).
+		SetBytes(outcome.Bytes).
+		SetItems(len(items)).
+		SetNewItems(outcome.NewItems).
+		SetEmptyFields(empty).
+		SetReasons(reasons).
+		SetFinishedAt(time.Now())
+	if outcome.HTTPStatus != 0 {
+		update.SetHTTPStatus(outcome.HTTPStatus)
+	}
+	if outcome.Snapshot != nil {
+		update.SetSnapshot(outcome.Snapshot)
+	}
+	if len(previous) > 0 {
+		update.SetBaseline(baseline)
+	}
+	if outcome.Err != nil {
+		update.SetError(outcome.Err.Error())
+	}
+	run, err = update.Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'enregistrement de l'exécution du scraper %s: %v", s.Name, err)
+	}
+
+	// Une erreur de récupération (site injoignable) ne dit rien de l'état du schema
+	switch {
+	case status == scraperun.StatusDegraded && !s.Degraded:
+		if err := client.Scraper.UpdateOne(s).SetDegraded(true).SetDegradedSince(run.CreatedAt).Exec(ctx); err != nil {
+			return run, fmt.Errorf("erreur lors de la mise à jour du scraper %s: %v", s.Name, err)
+		}
+		log.Printf("⚠️ Scraper %s dégradé: %v", s.Name, reasons)
+		if err := sendScraperAlert(ctx, client, s, run, 0); err != nil {
+			log.Printf("❌ %v", err)
+		}
+	case status == scraperun.StatusOk && s.Degraded:
+		update := client.Scraper.UpdateOne(s).SetDegraded(false).ClearDegradedSince()
+		if rebaseline {
+			// Les exécutions ok d'avant le changement du site ne comptent plus dans la moyenne
+			update.SetBaselineSince(run.CreatedAt)
+		}
+		if err := update.Exec(ctx); err != nil {
+			return run, fmt.Errorf("erreur lors de la mise à jour du scraper %s: %v", s.Name, err)
+		}
+		if rebaseline {
+			log.Printf("✅ Scraper %s rétabli, nouvelle référence de %d éléments", s.Name, len(items))
+		} else {
+			log.Printf("✅ Scraper %s rétabli (%d éléments)", s.Name, len(items))
+		}
+	case failureAlert:
+		log.Printf("⚠️ Scraper %s en échec %d fois de suite: %v", s.Name, failures, outcome.Err)
+		if err := sendScraperAlert(ctx, client, s, run, failures); err != nil {
+			log.Printf("❌ %v", err)
+		}
+	}
+
+	return run, nil
+}
+
+// lastRuns retourne les limit dernières exécutions terminées d'un scraper ayant l'un des statuts donnés, de la plus récente à la plus ancienne
+func lastRuns(ctx context.Context, client *ent.Client, s *ent.Scraper, limit int, statuses ...scraperun.Status) ([]*ent.ScrapeRun, error) {
+	runs, err := client.ScrapeRun.Query().
+		Where(
+			scraperun.HasScraperWith(scraper.IDEQ(s.ID)),
+			scraperun.StatusIn(statuses...),
+		).
+		Order(ent.Desc(scraperun.FieldCreatedAt), ent.Desc(scraperun.FieldID)).
+		Limit(limit).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des exécutions du scraper %s: %v", s.Name, err)
+	}
+	return runs, nil
+}
+
+// consecutive compte les exécutions qui ont le statut donné avant la première qui ne l'a pas
+func consecutive(runs []*ent.ScrapeRun, status scraperun.Status) int {
+	count := 0
+	for _, run := range runs {
+		if run.Status != status {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// healthReasons compare une exécution aux exécutions ok précédentes : trop peu d'éléments,
+// ou un champ toujours rempli jusque-là et vide pour tous les éléments
+func healthReasons(previous []*ent.ScrapeRun, items int, fields []string, matches map[string]int) (float64, []string) {
+	var reasons []string
+	if len(previous) == 0 {
+		if items == 0 {
+			reasons = append(reasons, "aucun élément trouvé : le sélecteur du conteneur ne correspond à rien")
+		}
+		return 0, reasons
+	}
+
+	total := 0
+	for _, run := range previous {
+		total += run.Items
+	}
+	baseline := float64(total) / float64(len(previous))
+
+	percent := intSetting("HEALTH_DEGRADED_PERCENT", 50)
+	threshold := baseline * float64(percent) / 100
+	if items == 0 {
+		reasons = append(reasons, "aucun élément trouvé : le sélecteur du conteneur ne correspond à rien")
+	} else if float64(items) < threshold {
+		reasons = append(reasons, fmt.Sprintf("%d éléments trouvés, moins de %d%% de la moyenne (%.1f)", items, percent, baseline))
+	}
+
+	if items > 0 {
+		for _, field := range fields {
+			if matches[field] == 0 && alwaysFilled(previous, field) {
+				reasons = append(reasons, fmt.Sprintf("le champ %s est vide pour tous les éléments", field))
+			}
+		}
+	}
+
+	return baseline, reasons
+}
+
+// alwaysFilled indique si un champ était rempli pour au moins un élément à chaque exécution
+func alwaysFilled(runs []*ent.ScrapeRun, field string) bool {
+	for _, run := range runs {
+		empty, ok := run.EmptyFields[field]
+		if !ok || empty >= run.Items {
+			return false
+		}
+	}
+	return true
+}
+
+// sendScraperAlert met en file l'alerte envoyée à ADMIN_EMAIL quand un scraper devient dégradé,
+// ou quand il a échoué failures fois de suite
+func sendScraperAlert(ctx context.Context, client *ent.Client, s *ent.Scraper, run *ent.ScrapeRun, failures int) error {
+	to := os.Getenv("ADMIN_EMAIL")
+	if to == "" {
+		return nil
+	}
+
+	from := os.Getenv("SMTP_EMAIL")
+	subject := "⚠️ Scraper dégradé : " + s.Name
+	if failures > 0 {
+		subject = "⚠️ Scraper en échec : " + s.Name
+	}
+
+	text, html, err := renderTemplates("alert", "default", ScraperAlertData{
+		Scraper:   s,
+		Run:       run,
+		Failures:  failures,
+		HealthURL: publicBaseURL() + "/scrapers/" + strconv.Itoa(s.ID) + "/health",
+	})
+	if err != nil {
+		return err
+	}
+
+	message := buildMessage(from, to, subject, nil, text, html, nil)
+
+	if _, err := enqueueEmail(ctx, client, from, to, subject, message); err != nil {
+		return err
+	}
+
+	log.Println("✅ Alerte mise en file pour", to)
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"tidy/ent"
+	"tidy/ent/scraperun"
+)
+
+func TestHealthReasons(t *testing.T) {
+	fields := []string{"title", "image"}
+	run := func(items int, emptyImages int) *ent.ScrapeRun {
+		return &ent.ScrapeRun{Items: items, EmptyFields: map[string]int{"title": 0, "image": emptyImages}}
+	}
+	previous := []*ent.ScrapeRun{run(10, 0), run(8, 2), run(12, 0)}
+
+	tests := []struct {
+		name         string
+		previous     []*ent.ScrapeRun
+		items        int
+		matches      map[string]int
+		wantBaseline float64
+		wantReasons  int
+	}{
+		{"first run with items", nil, 5, map[string]int{"title": 5, "image": 5}, 0, 0},
+		{"first run without items", nil, 0, map[string]int{"title": 0, "image": 0}, 0, 1},
+		{"as usual", previous, 10, map[string]int{"title": 10, "image": 10}, 10, 0},
+		{"at the threshold", previous, 5, map[string]int{"title": 5, "image": 5}, 10, 0},
+		{"under the threshold", previous, 4, map[string]int{"title": 4, "image": 4}, 10, 1},
+		{"no items", previous, 0, map[string]int{"title": 0, "image": 0}, 10, 1},
+		{"field always filled is now empty", previous, 10, map[string]int{"title": 10, "image": 0}, 10, 1},
+		{"field sometimes empty stays empty", []*ent.ScrapeRun{run(10, 10), run(10, 0)}, 10, map[string]int{"title": 10, "image": 0}, 10, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline, reasons := healthReasons(tt.previous, tt.items, fields, tt.matches)
+			if baseline != tt.wantBaseline {
+				t.Errorf("baseline = %v, want %v", baseline, tt.wantBaseline)
+			}
+			if len(reasons) != tt.wantReasons {
+				t.Errorf("reasons = %v, want %d reasons", reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+// healthScraper crée un scraper HTML et retourne une fonction qui enregistre une exécution de items éléments,
+// ou en échec si err n'est pas nil
+func healthScraper(t *testing.T, client *ent.Client) (*ent.Scraper, func(items int, err error) *ent.ScrapeRun) {
+	t.Helper()
+	ctx := context.Background()
+	s := client.Scraper.Create().SetName("Blog").SetLink("https://example.com/").SaveX(ctx)
+
+	record := func(items int, err error) *ent.ScrapeRun {
+		t.Helper()
+		// Le scraper est rechargé à chaque exécution, comme par executeScraperByID
+		current := client.Scraper.GetX(ctx, s.ID)
+		run, startErr := startScrapeRun(ctx, client, current, nil, scraperun.TriggerManual)
+		if startErr != nil {
+			t.Fatal(startErr)
+		}
+
+		outcome := scrapeOutcome{Err: err}
+		for i := 0; i < items; i++ {
+			outcome.Items = append(outcome.Items, map[string]interface{}{
+				"title":       "Article " + strconv.Itoa(i),
+				"description": "Description",
+				"image":       "https://example.com/image.jpg",
+				"time":        "2024-01-01",
+				"link":        "https://example.com/" + strconv.Itoa(i),
+			})
+		}
+
+		run, recordErr := recordScrapeRun(ctx, client, current, run, outcome)
+		if recordErr != nil {
+			t.Fatal(recordErr)
+		}
+		return run
+	}
+	return s, record
+}
+
+func TestRecordScrapeRunDegradesAndRecovers(t *testing.T) {
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	ctx := context.Background()
+	client := newTestClient(t)
+	s, record := healthScraper(t, client)
+
+	steps := []struct {
+		items        int
+		wantStatus   scraperun.Status
+		wantDegraded bool
+		wantAlerts   int
+	}{
+		{10, scraperun.StatusOk, false, 0},
+		{10, scraperun.StatusOk, false, 0},
+		{4, scraperun.StatusDegraded, true, 1},
+		// Une seule alerte tant que le scraper reste dégradé
+		{4, scraperun.StatusDegraded, true, 1},
+		// Les exécutions dégradées ne font pas baisser la référence
+		{10, scraperun.StatusOk, false, 1},
+	}
+
+	for i, step := range steps {
+		run := record(step.items, nil)
+		if run.Status != step.wantStatus {
+			t.Errorf("run %d: status = %s, want %s (reasons %v)", i, run.Status, step.wantStatus, run.Reasons)
+		}
+		if got := client.Scraper.GetX(ctx, s.ID).Degraded; got != step.wantDegraded {
+			t.Errorf("run %d: degraded = %v, want %v", i, got, step.wantDegraded)
+		}
+		if got := client.OutboundEmail.Query().CountX(ctx); got != step.wantAlerts {
+			t.Errorf("run %d: %d alerts, want %d", i, got, step.wantAlerts)
+		}
+	}
+}
+
+func TestRecordScrapeRunRebaselines(t *testing.T) {
+	t.Setenv("HEALTH_REBASELINE_RUNS", "3")
+	ctx := context.Background()
+	client := newTestClient(t)
+	s, record := healthScraper(t, client)
+
+	for i := 0; i < 3; i++ {
+		record(10, nil)
+	}
+
+	// Le site publie durablement moins d'articles : les HEALTH_REBASELINE_RUNS premières exécutions sont dégradées
+	for i := 0; i < 3; i++ {
+		if run := record(4, nil); run.Status != scraperun.StatusDegraded {
+			t.Fatalf("run %d after the change: status = %s, want %s", i, run.Status, scraperun.StatusDegraded)
+		}
+	}
+
+	// La suivante est comparée à ces exécutions, qui deviennent la nouvelle référence
+	run := record(4, nil)
+	if run.Status != scraperun.StatusOk || run.Baseline != 4 {
+		t.Fatalf("rebaseline run: status = %s, baseline = %v, want %s with baseline 4", run.Status, run.Baseline, scraperun.StatusOk)
+	}
+	updated := client.Scraper.GetX(ctx, s.ID)
+	if updated.Degraded || updated.BaselineSince == nil || !updated.BaselineSince.Equal(run.CreatedAt) {
+		t.Fatalf("scraper after rebaseline: degraded = %v, baseline_since = %v, want false and %s", updated.Degraded, updated.BaselineSince, run.CreatedAt)
+	}
+
+	// Les exécutions ok d'avant le changement ne comptent plus
+	if run := record(4, nil); run.Status != scraperun.StatusOk || run.Baseline != 4 {
+		t.Errorf("run after rebaseline: status = %s, baseline = %v, want %s with baseline 4", run.Status, run.Baseline, scraperun.StatusOk)
+	}
+}
+
+func TestRecordScrapeRunAlertsOnRepeatedFailures(t *testing.T) {
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	t.Setenv("HEALTH_FAILURE_ALERT_RUNS", "3")
+	ctx := context.Background()
+	client := newTestClient(t)
+	s, record := healthScraper(t, client)
+
+	record(10, nil)
+
+	fetchErr := errors.New("site injoignable")
+	for i, wantAlerts := range []int{0, 0, 1, 1, 1} {
+		run := record(0, fetchErr)
+		if run.Status != scraperun.StatusFailed || run.Error != fetchErr.Error() {
+			t.Errorf("failure %d: status = %s, error = %q", i+1, run.Status, run.Error)
+		}
+		if got := client.OutboundEmail.Query().CountX(ctx); got != wantAlerts {
+			t.Errorf("failure %d: %d alerts, want %d", i+1, got, wantAlerts)
+		}
+	}
+
+	// Un échec de récupération ne dit rien du schema
+	if client.Scraper.GetX(ctx, s.ID).Degraded {
+		t.Error("scraper marked degraded after fetch failures")
+	}
+
+	// Après un succès, une nouvelle série d'échecs déclenche une nouvelle alerte
+	record(10, nil)
+	for i := 0; i < 3; i++ {
+		record(0, fetchErr)
+	}
+	if got := client.OutboundEmail.Query().CountX(ctx); got != 2 {
+		t.Errorf("%d alerts after a second series of failures, want 2", got)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"sync"
+	"tidy/ent"
+	"time"
+)
+
+// inlineImage est une image jointe au message et référencée par son Content-ID (cid:)
+type inlineImage struct {
+	ContentID   string
+	ContentType string
+	Data        []byte
+}
+
+// thumbnailWidth retourne la largeur des miniatures, 100px par défaut comme dans le modèle HTML
+func thumbnailWidth() int {
+	return intSetting("IMAGE_THUMBNAIL_WIDTH", 100)
+}
+
+// fetchThumbnail télécharge une image, refuse celles qui dépassent IMAGE_MAX_BYTES ou IMAGE_MAX_PIXELS
+// et la réduit en miniature JPEG
+func fetchThumbnail(link string) ([]byte, error) {
+	response, err := fetchURL(context.Background(), fetchRequest{
+		URL:      link,
+		Accept:   "image/*",
+		MaxBytes: int64(intSetting("IMAGE_MAX_BYTES", 5*1024*1024)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors du téléchargement de l'image: %v", err)
+	}
+
+	// Les dimensions sont lues avant de décoder : une petite image compressée peut occuper des Go une fois décodée
+	config, _, err := image.DecodeConfig(bytes.NewReader(response.Body))
+	if err != nil {
+		return nil, fmt.Errorf("format d'image non supporté pour %s: %v", link, err)
+	}
+	maxPixels := intSetting("IMAGE_MAX_PIXELS", 25_000_000)
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image %s trop grande (%dx%d pixels)", link, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(response.Body))
+	if err != nil {
+		return nil, fmt.Errorf("format d'image non supporté pour %s: %v", link, err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizeImage(src, thumbnailWidth()), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("erreur lors de l'encodage de la miniature de %s: %v", link, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeImage réduit une image à la largeur donnée en moyennant les pixels de chaque zone
+func resizeImage(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		// Aplatir sur fond blanc pour l'encodage JPEG
+		dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+		return dst
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+
+			var r, g, b, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// Les pixels transparents sont mélangés avec un fond blanc
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr + (0xffff - pa))
+					g += uint64(pg + (0xffff - pa))
+					b += uint64(pb + (0xffff - pa))
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
+
+var (
+	placeholderOnce sync.Once
+	placeholderData []byte
+)
+
+// placeholderThumbnail retourne une miniature grise utilisée quand l'image d'un article est indisponible
+func placeholderThumbnail() []byte {
+	placeholderOnce.Do(func() {
+		width := thumbnailWidth()
+		img := image.NewRGBA(image.Rect(0, 0, width, width*2/3))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 0xdd, G: 0xdd, B: 0xdd, A: 0xff}}, image.Point{}, draw.Src)
+
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+		placeholderData = buf.Bytes()
+	})
+	return placeholderData
+}
+
+// imageCache garde les miniatures téléchargées pendant l'exécution d'un cron job,
+// pour ne pas retélécharger les mêmes images pour chaque abonné
+type imageCache struct {
+	mu      sync.Mutex
+	entries map[string]*imageEntry
+}
+
+// imageEntry est une miniature téléchargée ou en cours de téléchargement, done est fermé une fois data et err connus
+type imageEntry struct {
+	done      chan struct{}
+	data      []byte
+	err       error
+	fetchedAt time.Time
+}
+
+// expired indique un échec de téléchargement assez ancien pour réessayer
+func (e *imageEntry) expired() bool {
+	select {
+	case <-e.done:
+		return e.err != nil && time.Since(e.fetchedAt) >= thumbnailRetryDelay
+	default:
+		return false
+	}
+}
+
+func newImageCache() *imageCache {
+	return &imageCache{entries: make(map[string]*imageEntry)}
+}
+
+// thumbnail retourne la miniature d'une image, depuis le cache si elle a déjà été téléchargée.
+// Le verrou n'est pas gardé pendant le téléchargement : un hôte lent ne bloque que les demandes de la même image
+func (c *imageCache) thumbnail(link string) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[link]
+	if ok && !entry.expired() {
+		c.mu.Unlock()
+		<-entry.done
+		return entry.data, entry.err
+	}
+	entry = &imageEntry{done: make(chan struct{})}
+	c.entries[link] = entry
+	c.mu.Unlock()
+
+	entry.data, entry.err = fetchThumbnail(link)
+	entry.fetchedAt = time.Now()
+	close(entry.done)
+	return entry.data, entry.err
+}
+
+// prefetch télécharge en parallèle (IMAGE_FETCH_CONCURRENCY, 4 par défaut) les miniatures absentes du cache
+func (c *imageCache) prefetch(links []string) {
+	limit := make(chan struct{}, intSetting("IMAGE_FETCH_CONCURRENCY", 4))
+	var wg sync.WaitGroup
+	for _, link := range links {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(link string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			c.thumbnail(link)
+		}(link)
+	}
+	wg.Wait()
+}
+
+// thumbnailRetryDelay est la durée pendant laquelle l'échec du téléchargement d'une miniature est gardé en cache
+const thumbnailRetryDelay = 10 * time.Minute
+
+// articleThumbnails garde les miniatures servies par GET /thumbnails, par article : une newsletter ouverte
+// par tous ses abonnés ne télécharge chaque image qu'une fois. Au-delà de THUMBNAIL_CACHE_SIZE articles,
+// la plus ancienne entrée est retirée
+var articleThumbnails = &thumbnailCache{entries: make(map[int]thumbnailEntry)}
+
+type thumbnailCache struct {
+	mu      sync.Mutex
+	entries map[int]thumbnailEntry
+}
+
+type thumbnailEntry struct {
+	data     []byte
+	err      error
+	cachedAt time.Time
+}
+
+// thumbnail retourne la miniature de l'image d'un article, depuis le cache si elle a déjà été téléchargée
+func (c *thumbnailCache) thumbnail(a *ent.Article) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[a.ID]
+	c.mu.Unlock()
+	if ok && (entry.err == nil || time.Since(entry.cachedAt) < thumbnailRetryDelay) {
+		return entry.data, entry.err
+	}
+
+	data, err := fetchThumbnail(a.Image)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[a.ID]; !ok && len(c.entries) >= intSetting("THUMBNAIL_CACHE_SIZE", 1000) {
+		oldest := -1
+		for id, e := range c.entries {
+			if oldest == -1 || e.cachedAt.Before(c.entries[oldest].cachedAt) {
+				oldest = id
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[a.ID] = thumbnailEntry{data: data, err: err, cachedAt: time.Now()}
+	return data, err
+}
+
+// contentID retourne un Content-ID stable pour une image, les images identiques ne sont jointes qu'une fois
+func contentID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x@tidy", sum[:8])
+}
+
+// inlineImages télécharge les images des articles et les prépare en pièces jointes cid:,
+// au-delà de INLINE_IMAGES_MAX_BYTES par message les images sont remplacées par la miniature grise
+func inlineImages(cache *imageCache, sections []DigestSection) (map[int]string, []inlineImage) {
+	maxTotal := intSetting("INLINE_IMAGES_MAX_BYTES", 1024*1024)
+
+	sources := make(map[int]string)
+	attached := make(map[string]bool)
+	var images []inlineImage
+	total := 0
+
+	attach := func(data []byte) string {
+		id := contentID(data)
+		if !attached[id] {
+			attached[id] = true
+			images = append(images, inlineImage{ContentID: id, ContentType: "image/jpeg", Data: data})
+			total += len(data)
+		}
+		return "cid:" + id
+	}
+
+	var links []string
+	for _, section := range sections {
+		for _, a := range section.Articles {
+			if a.Image != "" {
+				links = append(links, a.Image)
+			}
+		}
+	}
+	cache.prefetch(links)
+
+	for _, section := range sections {
+		for _, a := range section.Articles {
+			if a.Image == "" {
+				continue
+			}
+
+			data, err := cache.thumbnail(a.Image)
+			if err != nil {
+				fmt.Printf("⚠️ %v\n", err)
+				data = placeholderThumbnail()
+			} else if !attached[contentID(data)] && total+len(data) > maxTotal {
+				fmt.Printf("⚠️ Limite de %d octets d'images atteinte, miniature grise pour %s\n", maxTotal, a.Image)
+				data = placeholderThumbnail()
+			}
+			sources[a.ID] = attach(data)
+		}
+	}
+
+	return sources, images
+}
+
+// proxiedImages retourne les liens signés vers les miniatures servies par l'API
+func proxiedImages(sections []DigestSection) (map[int]string, error) {
+	sources := make(map[int]string)
+	for _, section := range sections {
+		for _, a := range section.Articles {
+			if a.Image == "" {
+				continue
+			}
+
+			token, err := signToken("thumbnail", a.ID, 0)
+			if err != nil {
+				return nil, err
+			}
+			sources[a.ID] = publicURL("/thumbnails", token)
+		}
+	}
+	return sources, nil
+}
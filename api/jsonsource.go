@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"tidy/ent"
+	"tidy/ent/schema"
+	"tidy/ent/scraperschema"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// validateJSONRules vérifie qu'un schema JSON n'utilise que les règles applicables aux chemins gjson
+func validateJSONRules(scraperSchema *ent.ScraperSchema) error {
+	check := func(name string, rule schema.FieldRule) error {
+		if rule.Mode != "" || rule.Attribute != "" || rule.Language != "" {
+			return fmt.Errorf("le champ %s d'un schema json n'accepte que regex et trim", name)
+		}
+		return nil
+	}
+
+	for name, rule := range scraperSchema.Rules {
+		if err := check(name, rule); err != nil {
+			return err
+		}
+	}
+	for _, field := range scraperSchema.ExtraFields {
+		if err := check(field.Name, field.FieldRule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkJSONSchema vérifie qu'un scraper json a un schema au format json
+func checkJSONSchema(scraperDetails *ent.Scraper) error {
+	scraperSchema := scraperDetails.Edges.Schema
+	if scraperSchema == nil || scraperSchema.Format != scraperschema.FormatJSON {
+		return fmt.Errorf("le scraper %s de type json doit utiliser un schema au format json", scraperDetails.Name)
+	}
+	return nil
+}
+
+// extractJSON convertit les éléments de la réponse de l'API JSON d'un scraper en articles.
+// Le conteneur du schema est le chemin gjson de la liste (data.items, @this pour une liste à la racine),
+// les autres champs sont des chemins relatifs à chaque élément (title, media.0.url...).
+func extractJSON(scraperDetails *ent.Scraper, body []byte) ([]map[string]interface{}, error) {
+	scraperSchema := scraperDetails.Edges.Schema
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("réponse JSON invalide pour %s", scraperDetails.Link)
+	}
+
+	base, err := url.Parse(scraperDetails.Link)
+	if err != nil {
+		return nil, fmt.Errorf("URL de scraper invalide %s: %v", scraperDetails.Link, err)
+	}
+
+	list := gjson.GetBytes(body, scraperSchema.Container)
+	if !list.IsArray() {
+		return nil, fmt.Errorf("le chemin %s ne désigne pas une liste dans la réponse de %s", scraperSchema.Container, scraperDetails.Link)
+	}
+
+	items := make([]map[string]interface{}, 0)
+	for _, item := range list.Array() {
+		value := func(name string, path string) string {
+			return applyRule(jsonValue(item.Get(path)), scraperSchema.Rules[name])
+		}
+
+		extra := make(map[string]string, len(scraperSchema.ExtraFields))
+		for _, field := range scraperSchema.ExtraFields {
+			if v := applyRule(jsonValue(item.Get(field.Selector)), field.FieldRule); v != "" {
+				extra[field.Name] = v
+			}
+		}
+
+		items = append(items, map[string]interface{}{
+			"title":       htmlText(value("title", scraperSchema.Title)),
+			"description": htmlText(value("description", scraperSchema.Description)),
+			"image":       resolveURL(base, value("image", scraperSchema.Image)),
+			"time":        jsonDate(item.Get(scraperSchema.Time), scraperSchema.Rules["time"]),
+			"link":        resolveURL(base, value("link", scraperSchema.Link)),
+			"extra":       extra,
+		})
+	}
+
+	return items, nil
+}
+
+// jsonValue convertit une valeur gjson en texte, les listes de textes sont jointes (tags, auteurs)
+func jsonValue(result gjson.Result) string {
+	if result.IsArray() {
+		values := make([]string, 0)
+		for _, v := range result.Array() {
+			if v.Type == gjson.String || v.Type == gjson.Number {
+				values = append(values, v.String())
+			}
+		}
+		return strings.Join(values, ", ")
+	}
+	if result.IsObject() {
+		return ""
+	}
+	return result.String()
+}
+
+// jsonDate affiche une date d'API : timestamp Unix (secondes ou millisecondes) ou texte reconnu comme dans les flux
+func jsonDate(result gjson.Result, rule schema.FieldRule) string {
+	if result.Type == gjson.Number && rule.Regex == "" {
+		timestamp := result.Int()
+		if timestamp > 1e12 {
+			return time.UnixMilli(timestamp).Local().Format("02/01/2006, 15:04")
+		}
+		return time.Unix(timestamp, 0).Local().Format("02/01/2006, 15:04")
+	}
+	return feedDate(applyRule(jsonValue(result), rule))
+}
+
+// fetchJSON envoie la requête d'un scraper JSON avec ses paramètres et en-têtes personnalisés
+func fetchJSON(ctx context.Context, scraperDetails *ent.Scraper) ([]byte, int, error) {
+	response, err := fetchURL(ctx, fetchRequest{
+		URL:     scraperDetails.Link,
+		Accept:  "application/json",
+		Headers: scraperDetails.Headers,
+		Query:   scraperDetails.Query,
+	})
+	if err != nil {
+		if response != nil {
+			return nil, response.Status, err
+		}
+		return nil, 0, err
+	}
+	return response.Body, response.Status, nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mailer envoie un message déjà formaté (en-têtes et corps).
+// Close libère les ressources (connexion SMTP) une fois tous les messages envoyés.
+type Mailer interface {
+	Send(from string, to string, message []byte) error
+	Close() error
+}
+
+// newMailer crée le Mailer choisi par MAIL_BACKEND : "smtps" (par défaut), "smtp", "file" ou "log"
+func newMailer() (Mailer, error) {
+	backend := os.Getenv("MAIL_BACKEND")
+
+	// Nombre maximum de messages envoyés par minute (0 = illimité)
+	ratePerMinute, _ := strconv.Atoi(os.Getenv("MAIL_RATE_PER_MINUTE"))
+
+	switch backend {
+	case "", "smtps":
+		return &SMTPSMailer{
+			Host:          os.Getenv("SMTP_SERVER_NAME"),
+			Port:          os.Getenv("SMTP_PORT"),
+			Username:      os.Getenv("SMTP_EMAIL"),
+			Password:      os.Getenv("SMTP_PASSWORD"),
+			RatePerMinute: ratePerMinute,
+		}, nil
+	case "smtp":
+		// STARTTLS est obligatoire, sauf SMTP_TLS=none pour un relais local
+		tlsMode := os.Getenv("SMTP_TLS")
+		if tlsMode != "" && tlsMode != "starttls" && tlsMode != "none" {
+			return nil, fmt.Errorf("SMTP_TLS inconnu: %s (starttls ou none)", tlsMode)
+		}
+		return &SMTPMailer{
+			Host:          os.Getenv("SMTP_SERVER_NAME"),
+			Port:          os.Getenv("SMTP_PORT"),
+			Username:      os.Getenv("SMTP_EMAIL"),
+			Password:      os.Getenv("SMTP_PASSWORD"),
+			RatePerMinute: ratePerMinute,
+			Plaintext:     tlsMode == "none",
+		}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mails"
+		}
+		return &FileMailer{Dir: dir}, nil
+	case "log":
+		return &LogMailer{}, nil
+	}
+
+	return nil, fmt.Errorf("MAIL_BACKEND inconnu: %s", backend)
+}
+
+// SMTPSMailer envoie les messages en TLS implicite (port 465) sur une session réutilisée
+type SMTPSMailer struct {
+	Host          string
+	Port          string
+	Username      string
+	Password      string
+	RatePerMinute int
+
+	session smtpSession
+}
+
+func (m *SMTPSMailer) Send(from string, to string, message []byte) error {
+	return m.session.send(m.connect, m.RatePerMinute, from, to, message)
+}
+
+func (m *SMTPSMailer) Close() error {
+	return m.session.close()
+}
+
+func (m *SMTPSMailer) connect() (*smtp.Client, error) {
+	// Connexion SSL directe sur port
+	tlsconfig := &tls.Config{
+		ServerName: m.Host,
+	}
+
+	conn, err := tls.Dial("tcp", net.JoinHostPort(m.Host, m.Port), tlsconfig)
+	if err != nil {
+		return nil, fmt.Errorf("erreur TLS Dial : %v", err)
+	}
+
+	// Création du client SMTP à partir de la connexion sécurisée
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erreur création client SMTP : %v", err)
+	}
+
+	if err := smtpAuth(client, m.Host, m.Username, m.Password); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// SMTPMailer envoie les messages en SMTP classique (port 587 ou 25) chiffré par STARTTLS.
+// Plaintext autorise une session en clair, pour un relais local uniquement
+type SMTPMailer struct {
+	Host          string
+	Port          string
+	Username      string
+	Password      string
+	RatePerMinute int
+	Plaintext     bool
+
+	session smtpSession
+}
+
+func (m *SMTPMailer) Send(from string, to string, message []byte) error {
+	return m.session.send(m.connect, m.RatePerMinute, from, to, message)
+}
+
+func (m *SMTPMailer) Close() error {
+	return m.session.close()
+}
+
+func (m *SMTPMailer) connect() (*smtp.Client, error) {
+	client, err := smtp.Dial(net.JoinHostPort(m.Host, m.Port))
+	if err != nil {
+		return nil, fmt.Errorf("erreur connexion SMTP : %v", err)
+	}
+
+	// Sans STARTTLS, identifiants et messages passeraient en clair : un serveur qui ne le propose pas est refusé
+	if !m.Plaintext {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("le serveur SMTP %s ne propose pas STARTTLS (SMTP_TLS=none pour un relais local en clair)", m.Host)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("erreur STARTTLS : %v", err)
+		}
+	}
+
+	if err := smtpAuth(client, m.Host, m.Username, m.Password); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// smtpSession garde une connexion SMTP authentifiée ouverte entre deux envois
+type smtpSession struct {
+	mutex    sync.Mutex
+	client   *smtp.Client
+	used     bool
+	lastSend time.Time
+}
+
+// send envoie un message sur la session, en la (re)connectant si nécessaire.
+// Entre deux messages la transaction est réinitialisée (RSET) ; en cas d'erreur de connexion sur une
+// session déjà utilisée, on se reconnecte une fois avant d'abandonner. Un message refusé par le serveur
+// n'est pas renvoyé : la transaction est annulée et la session conservée pour les messages suivants.
+func (s *smtpSession) send(connect func() (*smtp.Client, error), ratePerMinute int, from string, to string, message []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.throttle(ratePerMinute)
+
+	reused := s.client != nil
+	err := s.sendOnce(connect, from, to, message)
+
+	var protocolErr *textproto.Error
+	if err != nil && errors.As(err, &protocolErr) && s.client != nil {
+		if resetErr := s.client.Reset(); resetErr != nil {
+			s.reset()
+		} else {
+			s.used = false
+		}
+		return err
+	}
+
+	if err != nil && reused {
+		log.Printf("🔁 Reconnexion SMTP après une erreur : %v", err)
+		s.reset()
+		err = s.sendOnce(connect, from, to, message)
+	}
+	if err != nil {
+		s.reset()
+		return err
+	}
+
+	s.lastSend = time.Now()
+	return nil
+}
+
+func (s *smtpSession) sendOnce(connect func() (*smtp.Client, error), from string, to string, message []byte) error {
+	if s.client == nil {
+		client, err := connect()
+		if err != nil {
+			return err
+		}
+		s.client = client
+		s.used = false
+	}
+
+	if s.used {
+		if err := s.client.Reset(); err != nil {
+			return fmt.Errorf("erreur RSET : %v", err)
+		}
+	}
+	s.used = true
+
+	return smtpSend(s.client, from, to, message)
+}
+
+// throttle attend le temps nécessaire pour respecter le nombre de messages par minute
+func (s *smtpSession) throttle(ratePerMinute int) {
+	if ratePerMinute <= 0 || s.lastSend.IsZero() {
+		return
+	}
+
+	interval := time.Minute / time.Duration(ratePerMinute)
+	if wait := time.Until(s.lastSend.Add(interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// reset ferme brutalement la connexion courante, la suivante sera rouverte à la demande
+func (s *smtpSession) reset() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+func (s *smtpSession) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+
+	err := s.client.Quit()
+	s.client.Close()
+	s.client = nil
+	return err
+}
+
+// smtpAuth s'authentifie si un mot de passe est configuré, le serveur doit alors proposer AUTH
+func smtpAuth(client *smtp.Client, host string, username string, password string) error {
+	if password == "" {
+		return nil
+	}
+	if ok, _ := client.Extension("AUTH"); !ok {
+		return fmt.Errorf("le serveur SMTP %s ne propose pas l'authentification alors que SMTP_PASSWORD est défini", host)
+	}
+
+	auth := smtp.PlainAuth("", username, password, host)
+	if err := client.Auth(auth); err != nil {
+		return fmt.Errorf("erreur authentification SMTP : %v", err)
+	}
+	return nil
+}
+
+// smtpSend envoie un message sur une session SMTP déjà ouverte
+func smtpSend(client *smtp.Client, from string, to string, message []byte) error {
+	// Préparation de l'e-mail
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("erreur MAIL FROM : %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("erreur RCPT TO : %w", err)
+	}
+
+	// Envoi du corps du message
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("erreur ouverture Data : %w", err)
+	}
+
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("erreur écriture du message : %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("erreur fermeture Data : %w", err)
+	}
+
+	return nil
+}
+
+// FileMailer écrit chaque message dans un fichier .eml, au format maildir (tmp/ puis new/)
+type FileMailer struct {
+	Dir string
+}
+
+var fileMailerCounter uint64
+
+func (m *FileMailer) Send(from string, to string, message []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0755); err != nil {
+			return fmt.Errorf("erreur création du dossier %s : %v", m.Dir, err)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().Unix(), os.Getpid(), atomic.AddUint64(&fileMailerCounter, 1), hostname)
+
+	// Écriture dans tmp/ puis déplacement dans new/ pour que le fichier apparaisse complet
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, message, 0644); err != nil {
+		return fmt.Errorf("erreur écriture du message : %v", err)
+	}
+
+	newPath := filepath.Join(m.Dir, "new", name)
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		return fmt.Errorf("erreur déplacement du message : %v", err)
+	}
+
+	log.Printf("📁 Message pour %s écrit dans %s", to, newPath)
+	return nil
+}
+
+func (m *FileMailer) Close() error {
+	return nil
+}
+
+// LogMailer affiche les messages sur la sortie standard au lieu de les envoyer
+type LogMailer struct{}
+
+func (m *LogMailer) Send(from string, to string, message []byte) error {
+	fmt.Printf("📧 ===== Message de %s pour %s =====\n%s\n📧 ===== Fin du message =====\n", from, to, message)
+	return nil
+}
+
+func (m *LogMailer) Close() error {
+	return nil
+}
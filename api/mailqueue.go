@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/textproto"
+	"os"
+	"strconv"
+	"tidy/ent"
+	"tidy/ent/article"
+	"tidy/ent/delivery"
+	"tidy/ent/outboundemail"
+	"tidy/ent/user"
+	"time"
+)
+
+// enqueueEmail enregistre un message dans la file d'envoi, il sera envoyé par le worker
+func enqueueEmail(ctx context.Context, client *ent.Client, from string, to string, subject string, message []byte) (*ent.OutboundEmail, error) {
+	email, err := client.OutboundEmail.Create().
+		SetFrom(from).
+		SetTo(to).
+		SetSubject(subject).
+		SetMessage(message).
+		Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la mise en file du message pour %s: %v", to, err)
+	}
+
+	return email, nil
+}
+
+// enqueueDigest met en file le récapitulatif d'un abonné et marque ses articles comme envoyés dans la même transaction.
+// Les articles restent marqués tant que le message est en file, pour ne pas être repris par le récapitulatif suivant,
+// et sont libérés si le message est abandonné
+func enqueueDigest(ctx context.Context, client *ent.Client, from string, u *ent.User, subject string, message []byte, articles []*ent.Article) (*ent.OutboundEmail, error) {
+	ids := make([]int, len(articles))
+	for i, a := range articles {
+		ids[i] = a.ID
+	}
+
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la mise en file du message pour %s: %v", u.Email, err)
+	}
+	defer tx.Rollback()
+
+	email, err := tx.OutboundEmail.Create().
+		SetFrom(from).
+		SetTo(u.Email).
+		SetSubject(subject).
+		SetMessage(message).
+		SetUserID(u.ID).
+		SetArticleIds(ids).
+		Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la mise en file du message pour %s: %v", u.Email, err)
+	}
+
+	if err := markDelivered(ctx, tx.Client(), u, articles); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erreur lors de la mise en file du message pour %s: %v", u.Email, err)
+	}
+	return email, nil
+}
+
+// releaseDeliveries libère les articles d'un récapitulatif abandonné, pour qu'ils soient repris au prochain envoi
+func releaseDeliveries(ctx context.Context, client *ent.Client, email *ent.OutboundEmail) error {
+	if email.UserID == 0 || len(email.ArticleIds) == 0 {
+		return nil
+	}
+
+	_, err := client.Delivery.Delete().
+		Where(
+			delivery.HasUserWith(user.IDEQ(email.UserID)),
+			delivery.HasArticleWith(article.IDIn(email.ArticleIds...)),
+		).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la libération des articles du message %d: %v", email.ID, err)
+	}
+	return nil
+}
+
+// reserveDeliveries marque à nouveau comme envoyés les articles d'un récapitulatif remis dans la file.
+// Les articles envoyés entre-temps par un autre récapitulatif, ou supprimés depuis, sont ignorés
+func reserveDeliveries(ctx context.Context, client *ent.Client, email *ent.OutboundEmail) error {
+	if email.UserID == 0 || len(email.ArticleIds) == 0 {
+		return nil
+	}
+
+	u, err := client.User.Get(ctx, email.UserID)
+	if ent.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erreur lors de la récupération de l'utilisateur %d: %v", email.UserID, err)
+	}
+
+	articles, err := client.Article.Query().
+		Where(article.IDIn(email.ArticleIds...)).
+		All(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la récupération des articles du message %d: %v", email.ID, err)
+	}
+
+	pending, err := undeliveredArticles(ctx, client, u, articles)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	return markDelivered(ctx, client, u, pending)
+}
+
+// intSetting lit un entier positif dans le .env, avec une valeur par défaut
+func intSetting(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// retryDelay retourne le délai avant la prochaine tentative : 30s, 1min, 2min, ... plafonné à 6h
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= 6*time.Hour {
+			return 6 * time.Hour
+		}
+	}
+	return delay
+}
+
+// permanentSendError indique un refus définitif du serveur SMTP (code 5xx, par exemple une adresse inconnue) :
+// le message est abandonné sans attendre les nouvelles tentatives, réservées aux erreurs temporaires (4xx, réseau)
+func permanentSendError(err error) bool {
+	var protocolErr *textproto.Error
+	return errors.As(err, &protocolErr) && protocolErr.Code >= 500
+}
+
+// startMailWorker lance en arrière-plan l'envoi des messages de la file
+func startMailWorker() {
+	interval := time.Duration(intSetting("MAIL_QUEUE_INTERVAL_SECONDS", 10)) * time.Second
+
+	client := getClient()
+	if err := migrateSchema(context.Background(), client); err != nil {
+		log.Fatalf("failed creating schema resources: %v", err)
+	}
+	client.Close()
+
+	// Un seul mailer pour toute la durée du worker : la session SMTP et la limite MAIL_RATE_PER_MINUTE
+	// sont conservées d'un lot à l'autre
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	go func() {
+		defer mailer.Close()
+		log.Printf("📮 Worker d'envoi des emails démarré (toutes les %s)", interval)
+		for {
+			client := getClient()
+			processMailQueue(context.Background(), client, mailer)
+			client.Close()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// processMailQueue envoie avec le mailer du worker les messages dont la prochaine tentative est échue
+func processMailQueue(ctx context.Context, client *ent.Client, mailer Mailer) {
+	batchSize := intSetting("MAIL_QUEUE_BATCH_SIZE", 50)
+	maxAttempts := intSetting("MAIL_MAX_ATTEMPTS", 6)
+
+	// Les messages restés "sending" au-delà du bail, après l'arrêt brutal d'un worker, sont remis dans la file.
+	// Ceux qu'un autre processus (serveur ou cron) est en train d'envoyer ne sont pas touchés
+	lease := time.Duration(intSetting("MAIL_CLAIM_LEASE_MINUTES", 10)) * time.Minute
+	reclaimed, err := client.OutboundEmail.Update().
+		Where(
+			outboundemail.StatusEQ(outboundemail.StatusSending),
+			outboundemail.Or(
+				outboundemail.ClaimedAtIsNil(),
+				outboundemail.ClaimedAtLT(time.Now().Add(-lease)),
+			),
+		).
+		SetStatus(outboundemail.StatusQueued).
+		ClearClaimedAt().
+		Save(ctx)
+	if err != nil {
+		log.Printf("❌ Erreur lors de la réinitialisation de la file d'envoi: %v", err)
+	} else if reclaimed > 0 {
+		log.Printf("🔁 %d messages abandonnés par un worker remis dans la file", reclaimed)
+	}
+
+	emails, err := client.OutboundEmail.Query().
+		Where(
+			outboundemail.StatusEQ(outboundemail.StatusQueued),
+			outboundemail.NextAttemptAtLTE(time.Now()),
+		).
+		Order(ent.Asc(outboundemail.FieldNextAttemptAt), ent.Asc(outboundemail.FieldID)).
+		Limit(batchSize).
+		All(ctx)
+	if err != nil {
+		log.Printf("❌ Erreur lors de la lecture de la file d'envoi: %v", err)
+		return
+	}
+
+	if len(emails) == 0 {
+		return
+	}
+
+	for _, email := range emails {
+		// Réserver le message, pour qu'un autre processus ne l'envoie pas en même temps
+		claimed, err := client.OutboundEmail.Update().
+			Where(
+				outboundemail.IDEQ(email.ID),
+				outboundemail.StatusEQ(outboundemail.StatusQueued),
+			).
+			SetStatus(outboundemail.StatusSending).
+			SetClaimedAt(time.Now()).
+			Save(ctx)
+		if err != nil || claimed == 0 {
+			continue
+		}
+
+		sendErr := mailer.Send(email.From, email.To, email.Message)
+		if sendErr == nil {
+			err = email.Update().
+				SetStatus(outboundemail.StatusSent).
+				SetAttempts(email.Attempts + 1).
+				SetSentAt(time.Now()).
+				ClearClaimedAt().
+				ClearLastError().
+				Exec(ctx)
+			if err != nil {
+				log.Printf("❌ Erreur lors de la mise à jour du message %d: %v", email.ID, err)
+			}
+			continue
+		}
+
+		attempts := email.Attempts + 1
+		update := email.Update().
+			SetAttempts(attempts).
+			ClearClaimedAt().
+			SetLastError(sendErr.Error())
+
+		dead := attempts >= maxAttempts || permanentSendError(sendErr)
+		if dead {
+			log.Printf("💀 Message %d pour %s abandonné après %d tentatives: %v", email.ID, email.To, attempts, sendErr)
+			update.SetStatus(outboundemail.StatusDead)
+		} else {
+			delay := retryDelay(attempts)
+			log.Printf("⏳ Échec de l'envoi du message %d pour %s (tentative %d), nouvel essai dans %s: %v", email.ID, email.To, attempts, delay, sendErr)
+			update.SetStatus(outboundemail.StatusQueued).
+				SetNextAttemptAt(time.Now().Add(delay))
+		}
+
+		if err := update.Exec(ctx); err != nil {
+			log.Printf("❌ Erreur lors de la mise à jour du message %d: %v", email.ID, err)
+			continue
+		}
+
+		if dead {
+			if err := releaseDeliveries(ctx, client, email); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"testing"
+	"tidy/ent"
+	"tidy/ent/enttest"
+	"tidy/ent/outboundemail"
+	"time"
+)
+
+// testDSN retourne une base SQLite en mémoire propre au test, partagée par ses connexions
+func testDSN(t *testing.T) string {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	return "file:" + name + "?mode=memory&cache=shared&_fk=1"
+}
+
+// newTestClient ouvre la base en mémoire du test, avec le schéma d'ent
+func newTestClient(t *testing.T) *ent.Client {
+	t.Helper()
+	client := enttest.Open(t, "sqlite3", testDSN(t))
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// fakeMailer échoue failures fois avec err puis envoie les messages, failures < 0 échoue toujours
+type fakeMailer struct {
+	failures int
+	err      error
+	sent     []string
+}
+
+func (m *fakeMailer) Send(from string, to string, message []byte) error {
+	if m.failures != 0 {
+		m.failures--
+		return m.err
+	}
+	m.sent = append(m.sent, to)
+	return nil
+}
+
+func (m *fakeMailer) Close() error {
+	return nil
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// makeDue rend un message en attente immédiatement envoyable, comme si son délai était écoulé
+func makeDue(t *testing.T, client *ent.Client, id int) {
+	t.Helper()
+	if err := client.OutboundEmail.UpdateOneID(id).SetNextAttemptAt(time.Now().Add(-time.Second)).Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessMailQueue(t *testing.T) {
+	transient := errors.New("connection reset by peer")
+	permanent := fmt.Errorf("erreur RCPT TO : %w", &textproto.Error{Code: 550, Msg: "mailbox unavailable"})
+
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		rounds       int
+		wantStatus   outboundemail.Status
+		wantAttempts int
+		wantSent     int
+	}{
+		{"sent at first attempt", 0, nil, 1, outboundemail.StatusSent, 1, 1},
+		{"transient failure is retried", 1, transient, 1, outboundemail.StatusQueued, 1, 0},
+		{"sent after transient failures", 2, transient, 3, outboundemail.StatusSent, 3, 1},
+		{"dead after max attempts", -1, transient, 3, outboundemail.StatusDead, 3, 0},
+		{"temporary rejection is retried", 1, &textproto.Error{Code: 451, Msg: "try again later"}, 1, outboundemail.StatusQueued, 1, 0},
+		{"permanent rejection is dead at once", -1, permanent, 1, outboundemail.StatusDead, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAIL_MAX_ATTEMPTS", "3")
+			ctx := context.Background()
+			client := newTestClient(t)
+			mailer := &fakeMailer{failures: tt.failures, err: tt.err}
+
+			email, err := enqueueEmail(ctx, client, "bot@example.com", "user@example.com", "Sujet", []byte("message"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for round := 0; round < tt.rounds; round++ {
+				makeDue(t, client, email.ID)
+				processMailQueue(ctx, client, mailer)
+			}
+
+			got := client.OutboundEmail.GetX(ctx, email.ID)
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if got.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got.Attempts, tt.wantAttempts)
+			}
+			if len(mailer.sent) != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", len(mailer.sent), tt.wantSent)
+			}
+			if got.ClaimedAt != nil {
+				t.Errorf("claimed_at = %v, want nil", got.ClaimedAt)
+			}
+
+			switch got.Status {
+			case outboundemail.StatusSent:
+				if got.SentAt == nil || got.LastError != "" {
+					t.Errorf("sent message has sent_at %v and last_error %q", got.SentAt, got.LastError)
+				}
+			case outboundemail.StatusQueued, outboundemail.StatusDead:
+				if got.LastError != tt.err.Error() {
+					t.Errorf("last_error = %q, want %q", got.LastError, tt.err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestProcessMailQueueBacksOff(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	mailer := &fakeMailer{failures: -1, err: errors.New("timeout")}
+
+	email, err := enqueueEmail(ctx, client, "bot@example.com", "user@example.com", "Sujet", []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		makeDue(t, client, email.ID)
+		before := time.Now()
+		processMailQueue(ctx, client, mailer)
+
+		got := client.OutboundEmail.GetX(ctx, email.ID)
+		want := before.Add(retryDelay(attempt))
+		if got.NextAttemptAt.Before(want) || got.NextAttemptAt.After(want.Add(5*time.Second)) {
+			t.Errorf("attempt %d: next_attempt_at = %s, want about %s", attempt, got.NextAttemptAt, want)
+		}
+
+		// Un message dont le délai n'est pas écoulé n'est pas renvoyé
+		processMailQueue(ctx, client, mailer)
+		if again := client.OutboundEmail.GetX(ctx, email.ID); again.Attempts != attempt {
+			t.Errorf("attempt %d: message sent again before its delay, attempts = %d", attempt, again.Attempts)
+		}
+	}
+}
+
+func TestProcessMailQueueReclaimsExpiredClaims(t *testing.T) {
+	t.Setenv("MAIL_CLAIM_LEASE_MINUTES", "10")
+	ctx := context.Background()
+	client := newTestClient(t)
+	mailer := &fakeMailer{}
+
+	claim := func(to string, claimedAt time.Time) int {
+		email, err := client.OutboundEmail.Create().
+			SetFrom("bot@example.com").
+			SetTo(to).
+			SetSubject("Sujet").
+			SetMessage([]byte("message")).
+			SetStatus(outboundemail.StatusSending).
+			SetClaimedAt(claimedAt).
+			SetNextAttemptAt(time.Now().Add(-time.Minute)).
+			Save(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return email.ID
+	}
+	abandoned := claim("abandoned@example.com", time.Now().Add(-20*time.Minute))
+	inFlight := claim("inflight@example.com", time.Now().Add(-time.Minute))
+
+	processMailQueue(ctx, client, mailer)
+
+	if got := client.OutboundEmail.GetX(ctx, abandoned); got.Status != outboundemail.StatusSent {
+		t.Errorf("abandoned message status = %s, want %s", got.Status, outboundemail.StatusSent)
+	}
+	if got := client.OutboundEmail.GetX(ctx, inFlight); got.Status != outboundemail.StatusSending {
+		t.Errorf("in-flight message status = %s, want %s", got.Status, outboundemail.StatusSending)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0] != "abandoned@example.com" {
+		t.Errorf("sent = %v, want [abandoned@example.com]", mailer.sent)
+	}
+}
+
+func TestDeadDigestReleasesDeliveries(t *testing.T) {
+	t.Setenv("MAIL_MAX_ATTEMPTS", "1")
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	u := client.User.Create().SetEmail("user@example.com").SaveX(ctx)
+	a := client.Article.Create().
+		SetTitle("Titre").
+		SetDescription("").
+		SetImage("").
+		SetTime("").
+		SetLink("https://example.com/a").
+		SaveX(ctx)
+
+	email, err := enqueueDigest(ctx, client, "bot@example.com", u, "Sujet", []byte("message"), []*ent.Article{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := undeliveredArticles(ctx, client, u, []*ent.Article{a}); len(pending) != 0 {
+		t.Fatalf("queued article still pending: %v", pending)
+	}
+
+	processMailQueue(ctx, client, &fakeMailer{failures: -1, err: errors.New("timeout")})
+
+	if got := client.OutboundEmail.GetX(ctx, email.ID); got.Status != outboundemail.StatusDead {
+		t.Fatalf("status = %s, want %s", got.Status, outboundemail.StatusDead)
+	}
+	if pending, _ := undeliveredArticles(ctx, client, u, []*ent.Article{a}); len(pending) != 1 {
+		t.Errorf("dead-lettered article not released, pending = %v", pending)
+	}
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"log"
 	"os"
+
+	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Le .env est chargé une seule fois au démarrage, il est facultatif quand les variables sont déjà définies
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Aucun fichier .env chargé, utilisation des variables d'environnement")
+	}
+
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "seed":
@@ -14,6 +21,9 @@ func main() {
 		case "clear":
 			clearData()
 			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
 		case "help":
 			log.Printf("Usage:")
 			log.Printf("  go run .          - Lance l'application normale")
@@ -21,19 +31,23 @@ func main() {
 			log.Printf("  go run . clear    - Supprime toutes les données")
 			log.Printf("  go run . test     - Teste les relations")
 			log.Printf("  go run . server   - Lance seulement le serveur API")
+			log.Printf("  go run . replay <scraperID> <schemaID|fichier.json> [N] - Compare un schema candidat au schema actuel sur les N derniers snapshots")
 			log.Printf("  go run . cron - Lance seulement les cron jobs")
 			log.Printf("  go run . help     - Affiche cette aide")
 			return
 		case "server":
 			log.Printf("Lancement du serveur API")
+			startMailWorker()
 			startServer()
 			return
 		case "cron":
 			log.Printf("Lancement des Cron Jobs")
+			startMailWorker()
 			startCron()
 			select {}
 		}
 	}
+	startMailWorker()
 	startCron()
 	startServer()
 }
\ No newline at end of file
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"tidy/ent"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// databaseDSN est la base SQLite de l'application, partagée par le client ent et les migrations en SQL
+const databaseDSN = "file:test.db?_fk=1"
+
+// Versions des données de la base (PRAGMA user_version) : chaque reprise de données l'augmente,
+// pour qu'elle ne soit exécutée qu'une fois
+const (
+	dataVersionSubscriptions  = 1 // utilisateurs de l'ancien modèle repris en abonnements
+	dataVersionCanonicalLinks = 2 // liens des articles normalisés par canonicalLink
+)
+
+// migrateSchema met à jour la base : les données de l'ancien modèle sont reprises avant la migration automatique
+// d'ent, puis les abonnements de l'ancien modèle sont recréés une fois la table des abonnements créée
+// et les liens des articles normalisés
+func migrateSchema(ctx context.Context, client *ent.Client) error {
+	db, err := sql.Open("sqlite3", databaseDSN)
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'ouverture de la base: %v", err)
+	}
+	defer db.Close()
+
+	version, err := dataVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if version < dataVersionSubscriptions {
+		if err := migrateLegacyUsers(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Schema.Create(ctx); err != nil {
+		return err
+	}
+
+	if version < dataVersionSubscriptions {
+		if err := backfillLegacySubscriptions(ctx, db); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", dataVersionSubscriptions)); err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour de la version de la base: %v", err)
+		}
+	}
+
+	return canonicalizeArticleLinks(ctx, db)
+}
+
+// dataVersion retourne la version des données de la base (PRAGMA user_version)
+func dataVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("erreur lors de la lecture de la version de la base: %v", err)
+	}
+	return version, nil
+}
+
+// migrateLegacyUsers convertit la table users de l'ancien modèle, où chaque utilisateur appartenait à une seule
+// newsletter (colonne newsletter_users) : les utilisateurs de même email sont fusionnés, leurs newsletters sont
+// mises de côté dans legacy_user_newsletters et la colonne est supprimée
+func migrateLegacyUsers(ctx context.Context, db *sql.DB) error {
+	var legacy int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'newsletter_users'").Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la lecture de la table users: %v", err)
+	}
+	if legacy == 0 {
+		return nil
+	}
+
+	// SQLite ne peut pas supprimer une colonne avec une clé étrangère : la table est reconstruite,
+	// ce qui demande de désactiver les clés étrangères sur la connexion, hors transaction
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur lors de l'ouverture de la base: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("erreur lors de la désactivation des clés étrangères: %v", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la migration des utilisateurs: %v", err)
+	}
+	defer tx.Rollback()
+
+	var subscriptions int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'subscriptions'").Scan(&subscriptions); err != nil {
+		return fmt.Errorf("erreur lors de la migration des utilisateurs: %v", err)
+	}
+	var deliveries int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'deliveries'").Scan(&deliveries); err != nil {
+		return fmt.Errorf("erreur lors de la migration des utilisateurs: %v", err)
+	}
+
+	statements := []string{
+		// Utilisateur conservé pour chaque email : le plus ancien
+		`CREATE TEMP TABLE user_merges AS
+			SELECT u.id AS old_id, k.id AS new_id
+			FROM users u
+			JOIN (SELECT MIN(id) AS id, LOWER(TRIM(email)) AS email FROM users GROUP BY LOWER(TRIM(email))) k
+				ON k.email = LOWER(TRIM(u.email))`,
+		`CREATE TABLE IF NOT EXISTS legacy_user_newsletters (user_id integer NOT NULL, newsletter_id integer NOT NULL, PRIMARY KEY (user_id, newsletter_id))`,
+		`INSERT OR IGNORE INTO legacy_user_newsletters (user_id, newsletter_id)
+			SELECT m.new_id, u.newsletter_users FROM users u JOIN user_merges m ON m.old_id = u.id
+			WHERE u.newsletter_users IS NOT NULL`,
+	}
+	if deliveries > 0 {
+		statements = append(statements,
+			// Un article déjà envoyé à un doublon ne doit pas être renvoyé à l'utilisateur conservé
+			`UPDATE OR IGNORE deliveries SET user_deliveries = (SELECT new_id FROM user_merges WHERE old_id = user_deliveries)`,
+			`DELETE FROM deliveries WHERE user_deliveries IN (SELECT old_id FROM user_merges WHERE old_id <> new_id)`,
+		)
+	}
+	if subscriptions > 0 {
+		statements = append(statements,
+			`UPDATE OR IGNORE subscriptions SET user_id = (SELECT new_id FROM user_merges WHERE old_id = user_id)`,
+			`DELETE FROM subscriptions WHERE user_id IN (SELECT old_id FROM user_merges WHERE old_id <> new_id)`,
+		)
+	}
+	statements = append(statements,
+		`CREATE TABLE users_migrated (id integer NOT NULL PRIMARY KEY AUTOINCREMENT, email text NOT NULL)`,
+		`INSERT INTO users_migrated (id, email)
+			SELECT id, LOWER(TRIM(email)) FROM users WHERE id IN (SELECT new_id FROM user_merges)`,
+		`DROP TABLE users`,
+		`ALTER TABLE users_migrated RENAME TO users`,
+		`DROP TABLE user_merges`,
+	)
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("erreur lors de la migration des utilisateurs: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erreur lors de la migration des utilisateurs: %v", err)
+	}
+
+	log.Println("✅ Utilisateurs migrés vers les abonnements")
+	return nil
+}
+
+// backfillLegacySubscriptions crée un abonnement actif pour chaque newsletter mise de côté par migrateLegacyUsers
+func backfillLegacySubscriptions(ctx context.Context, db *sql.DB) error {
+	var pending int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'legacy_user_newsletters'").Scan(&pending)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la reprise des abonnements: %v", err)
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la reprise des abonnements: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Ces utilisateurs recevaient déjà la newsletter : l'abonnement est actif sans nouvelle confirmation
+	result, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO subscriptions (user_id, newsletter_id, status, created_at)
+			SELECT l.user_id, l.newsletter_id, 'active', ? FROM legacy_user_newsletters l
+			WHERE EXISTS (SELECT 1 FROM newsletters n WHERE n.id = l.newsletter_id)`,
+		time.Now())
+	if err != nil {
+		return fmt.Errorf("erreur lors de la reprise des abonnements: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DROP TABLE legacy_user_newsletters"); err != nil {
+		return fmt.Errorf("erreur lors de la reprise des abonnements: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erreur lors de la reprise des abonnements: %v", err)
+	}
+
+	created, _ := result.RowsAffected()
+	log.Printf("✅ %d abonnements repris de l'ancien modèle", created)
+	return nil
+}
+
+// canonicalizeArticleLinks normalise les liens des articles enregistrés avant canonicalLink. Les articles dont les liens
+// ont la même forme canonique sont fusionnés dans le plus ancien, avec leurs envois, pour ne pas être renvoyés aux abonnés
+func canonicalizeArticleLinks(ctx context.Context, db *sql.DB) error {
+	version, err := dataVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version >= dataVersionCanonicalLinks {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la normalisation des liens: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, link FROM articles ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("erreur lors de la normalisation des liens: %v", err)
+	}
+	type article struct {
+		id   int
+		link string
+	}
+	var articles []article
+	for rows.Next() {
+		var a article
+		if err := rows.Scan(&a.id, &a.link); err != nil {
+			rows.Close()
+			return fmt.Errorf("erreur lors de la normalisation des liens: %v", err)
+		}
+		articles = append(articles, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("erreur lors de la normalisation des liens: %v", err)
+	}
+
+	// Les doublons sont supprimés avant de renommer les articles conservés, pour respecter l'unicité des liens
+	kept := make(map[string]int, len(articles))
+	renamed := make(map[int]string)
+	merged := 0
+	for _, a := range articles {
+		link := canonicalLink(a.link)
+		keep, ok := kept[link]
+		if !ok {
+			kept[link] = a.id
+			if link != a.link {
+				renamed[a.id] = link
+			}
+			continue
+		}
+
+		statements := []string{
+			"UPDATE OR IGNORE deliveries SET article_deliveries = ? WHERE article_deliveries = ?",
+			"DELETE FROM deliveries WHERE article_deliveries = ?",
+			"DELETE FROM articles WHERE id = ?",
+		}
+		if _, err := tx.ExecContext(ctx, statements[0], keep, a.id); err != nil {
+			return fmt.Errorf("erreur lors de la fusion de l'article %d: %v", a.id, err)
+		}
+		for _, statement := range statements[1:] {
+			if _, err := tx.ExecContext(ctx, statement, a.id); err != nil {
+				return fmt.Errorf("erreur lors de la fusion de l'article %d: %v", a.id, err)
+			}
+		}
+		merged++
+	}
+
+	for id, link := range renamed {
+		if _, err := tx.ExecContext(ctx, "UPDATE articles SET link = ? WHERE id = ?", link, id); err != nil {
+			return fmt.Errorf("erreur lors de la normalisation du lien de l'article %d: %v", id, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", dataVersionCanonicalLinks)); err != nil {
+		return fmt.Errorf("erreur lors de la mise à jour de la version de la base: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erreur lors de la normalisation des liens: %v", err)
+	}
+
+	if len(renamed) > 0 || merged > 0 {
+		log.Printf("✅ Liens des articles normalisés : %d modifiés, %d doublons fusionnés", len(renamed), merged)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"tidy/ent"
+	"tidy/ent/article"
+	"tidy/ent/delivery"
+	"tidy/ent/user"
+)
+
+func TestCanonicalizeArticleLinks(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	db, err := sql.Open("sqlite3", testDSN(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	newArticle := func(link string) *ent.Article {
+		return client.Article.Create().
+			SetTitle(link).
+			SetDescription("").
+			SetImage("").
+			SetTime("").
+			SetLink(link).
+			SaveX(ctx)
+	}
+	// Les deux premiers liens ont la même forme canonique, enregistrés avant canonicalLink
+	kept := newArticle("https://Example.com/a?utm_source=rss")
+	duplicate := newArticle("https://example.com:443/a#comments")
+	other := newArticle("https://example.com/b")
+
+	alice := client.User.Create().SetEmail("alice@example.com").SaveX(ctx)
+	bob := client.User.Create().SetEmail("bob@example.com").SaveX(ctx)
+	client.Delivery.Create().SetUser(alice).SetArticle(kept).ExecX(ctx)
+	client.Delivery.Create().SetUser(alice).SetArticle(duplicate).ExecX(ctx)
+	client.Delivery.Create().SetUser(bob).SetArticle(duplicate).ExecX(ctx)
+
+	if err := canonicalizeArticleLinks(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	articles := client.Article.Query().Order(ent.Asc(article.FieldID)).AllX(ctx)
+	if len(articles) != 2 {
+		t.Fatalf("got %d articles, want 2", len(articles))
+	}
+	if articles[0].ID != kept.ID || articles[0].Link != "https://example.com/a" {
+		t.Errorf("kept article = %d %q, want %d %q", articles[0].ID, articles[0].Link, kept.ID, "https://example.com/a")
+	}
+	if articles[1].ID != other.ID || articles[1].Link != other.Link {
+		t.Errorf("other article = %d %q, want unchanged", articles[1].ID, articles[1].Link)
+	}
+
+	// Les envois du doublon sont reportés sur l'article conservé, sans créer de doublon d'envoi
+	for _, u := range []*ent.User{alice, bob} {
+		ids := client.Delivery.Query().
+			Where(delivery.HasUserWith(user.IDEQ(u.ID))).
+			QueryArticle().
+			IDsX(ctx)
+		sort.Ints(ids)
+		if len(ids) != 1 || ids[0] != kept.ID {
+			t.Errorf("deliveries of %s = %v, want [%d]", u.Email, ids, kept.ID)
+		}
+	}
+
+	version, err := dataVersion(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != dataVersionCanonicalLinks {
+		t.Errorf("user_version = %d, want %d", version, dataVersionCanonicalLinks)
+	}
+
+	// Une fois la version enregistrée, la normalisation n'est plus rejouée
+	late := newArticle("https://example.com/c#late")
+	if err := canonicalizeArticleLinks(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Article.GetX(ctx, late.ID); got.Link != late.Link {
+		t.Errorf("link normalized again after the migration: %q", got.Link)
+	}
+}
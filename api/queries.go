@@ -5,13 +5,14 @@ import (
 	"log"
 	"tidy/ent"
 	"tidy/ent/newsletter"
+	"tidy/ent/subscription"
 	"tidy/ent/user"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func subscribe(email string, newsletterName string) {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -27,19 +28,20 @@ func subscribe(email string, newsletterName string) {
 		log.Fatalf("failed getting newsletter '%s': %v", newsletterName, err)
 	}
 
-	user, err := client.User.
-		Create().
-		SetEmail(email).
-		SetNewsletter(newsletter).
-		Save(ctx)
+	user, err := findOrCreateUser(ctx, client, email)
 	if err != nil {
 		log.Fatalf("failed creating user: %v", err)
 	}
-	log.Printf("user subscribed to newsletter: %v", user)
+
+	subscription, err := requestSubscription(ctx, client, user.ID, newsletter.ID, nil)
+	if err != nil {
+		log.Fatalf("failed subscribing user: %v", err)
+	}
+	log.Printf("user subscription pending confirmation: %v (%v)", user, subscription)
 }
 
 func deleteUser(email string) {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -56,9 +58,7 @@ func deleteUser(email string) {
 		log.Fatalf("user not found : %v", err)
 	}
 
-	err = client.User.
-		DeleteOneID(u.ID).
-		Exec(ctx)
+	err = deleteUserWithSubscriptions(ctx, client, u.ID)
 
 	if err != nil {
 		log.Fatalf("error while deleting the user : %v", err)
@@ -68,7 +68,7 @@ func deleteUser(email string) {
 }
 
 func getUsers() ([]*ent.User) {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -76,12 +76,15 @@ func getUsers() ([]*ent.User) {
 
 	ctx := context.Background()
 
-	if err := client.Schema.Create(ctx); err != nil {
+	if err := migrateSchema(ctx, client); err != nil {
 		log.Fatalf("failed creating schema resources: %v", err)
 	}
 
 	users, err := client.User.Query().
-		WithNewsletter().
+		WithSubscriptions(func(q *ent.SubscriptionQuery) {
+			q.Where(subscription.StatusEQ(subscription.StatusActive)).
+				WithNewsletter()
+		}).
 		All(ctx)
 	if err != nil {
 		log.Fatalf("failed querying users: %v", err)
@@ -91,7 +94,7 @@ func getUsers() ([]*ent.User) {
 }
 
 func getCronJobs() ([]*ent.CronJob) {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -99,7 +102,7 @@ func getCronJobs() ([]*ent.CronJob) {
 
 	ctx := context.Background()
 
-	if err := client.Schema.Create(ctx); err != nil {
+	if err := migrateSchema(ctx, client); err != nil {
 		log.Fatalf("failed creating schema resources: %v", err)
 	}
 
@@ -117,7 +120,7 @@ func getCronJobs() ([]*ent.CronJob) {
 }
 
 func connect() (*ent.Client) {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -126,7 +129,7 @@ func connect() (*ent.Client) {
 	ctx := context.Background()
 
 	// Migration automatique
-	if err := client.Schema.Create(ctx); err != nil {
+	if err := migrateSchema(ctx, client); err != nil {
 		log.Fatalf("failed creating schema resources: %v", err)
 	}
 
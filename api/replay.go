@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"tidy/ent"
+	"tidy/ent/scraper"
+	"tidy/ent/scraperun"
+	"tidy/ent/snapshot"
+	"time"
+)
+
+// ReplayResult compare les articles extraits des derniers snapshots d'un scraper par son schema actuel et par un schema candidat
+type ReplayResult struct {
+	ScraperID int            `json:"scraper_id"`
+	Name      string         `json:"name"`
+	Snapshots []ReplayReport `json:"snapshots"`
+}
+
+// ReplayReport est la comparaison pour un snapshot : nombre d'articles, champs trouvés et différences
+type ReplayReport struct {
+	RunID     int       `json:"run_id"`
+	Snapshot  string    `json:"snapshot"`
+	FetchedAt time.Time `json:"fetched_at"`
+
+	Current   ReplaySide `json:"current"`
+	Candidate ReplaySide `json:"candidate"`
+
+	Added   []map[string]interface{} `json:"added"`   // articles trouvés seulement par le schema candidat
+	Removed []map[string]interface{} `json:"removed"` // articles que le schema candidat ne trouve plus
+	Changed []ReplayChange           `json:"changed"`
+}
+
+// ReplaySide résume l'extraction d'un snapshot par un schema
+type ReplaySide struct {
+	Count   int            `json:"count"`
+	Matches map[string]int `json:"matches"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// ReplayChange liste les champs d'un article qui diffèrent entre les deux schemas, [actuel, candidat]
+type ReplayChange struct {
+	Link   string               `json:"link"`
+	Fields map[string][2]string `json:"fields"`
+}
+
+// replaySchema réapplique le schema actuel et un schema candidat aux snapshots des dernières exécutions d'un scraper.
+// Le scraper doit être chargé avec son schema, les snapshots identiques ne sont comparés qu'une fois
+func replaySchema(ctx context.Context, client *ent.Client, s *ent.Scraper, candidate *ent.ScraperSchema, limit int) (*ReplayResult, error) {
+	kind := scraperKind(s)
+	if isFeedKind(kind) {
+		return nil, fmt.Errorf("le scraper %s de type %s n'utilise pas de schema", s.Name, kind)
+	}
+	if candidate.Format != schemaFormatFor(kind) {
+		return nil, fmt.Errorf("le scraper %s de type %s doit utiliser un schema au format %s", s.Name, kind, schemaFormatFor(kind))
+	}
+
+	runs, err := latestSnapshotRuns(ctx, client, s, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	current := *s
+	proposed := *s
+	proposed.Edges.Schema = candidate
+
+	result := &ReplayResult{
+		ScraperID: s.ID,
+		Name:      s.Name,
+		Snapshots: []ReplayReport{},
+	}
+	for _, run := range runs {
+		snap := run.Edges.Snapshot
+		full, err := client.Snapshot.Get(ctx, snap.ID)
+		if err != nil {
+			return nil, fmt.Errorf("erreur lors de la récupération du snapshot %s: %v", snap.Hash, err)
+		}
+		body, err := snapshotContent(full)
+		if err != nil {
+			return nil, err
+		}
+
+		report := ReplayReport{
+			RunID:     run.ID,
+			Snapshot:  snap.Hash,
+			FetchedAt: run.CreatedAt,
+		}
+		before, side := replaySide(&current, body)
+		report.Current = side
+		after, side := replaySide(&proposed, body)
+		report.Candidate = side
+
+		fields := scraperFields(&current)
+		for _, field := range scraperFields(&proposed) {
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+		report.Added, report.Removed, report.Changed = diffArticles(before, after, fields)
+
+		result.Snapshots = append(result.Snapshots, report)
+	}
+
+	return result, nil
+}
+
+// latestSnapshotRuns retourne les exécutions les plus récentes d'un scraper ayant chacune un snapshot différent,
+// au plus limit. Les exécutions sont lues par pages, une page étant souvent dominée par un même snapshot
+func latestSnapshotRuns(ctx context.Context, client *ent.Client, s *ent.Scraper, limit int) ([]*ent.ScrapeRun, error) {
+	pageSize := max(limit, 50)
+	runs := []*ent.ScrapeRun{}
+	seen := make(map[string]bool)
+	for offset := 0; len(runs) < limit; offset += pageSize {
+		page, err := client.ScrapeRun.Query().
+			Where(
+				scraperun.HasScraperWith(scraper.IDEQ(s.ID)),
+				scraperun.HasSnapshot(),
+			).
+			WithSnapshot(func(q *ent.SnapshotQuery) {
+				// Le contenu n'est chargé que pour les snapshots comparés
+				q.Select(snapshot.FieldHash)
+			}).
+			Order(ent.Desc(scraperun.FieldCreatedAt), ent.Desc(scraperun.FieldID)).
+			Limit(pageSize).
+			Offset(offset).
+			All(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("erreur lors de la récupération des snapshots du scraper %s: %v", s.Name, err)
+		}
+
+		for _, run := range page {
+			hash := run.Edges.Snapshot.Hash
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			runs = append(runs, run)
+			if len(runs) >= limit {
+				break
+			}
+		}
+		if len(page) < pageSize {
+			break
+		}
+	}
+	return runs, nil
+}
+
+// replaySide extrait les articles d'un snapshot avec le schema d'un scraper
+func replaySide(s *ent.Scraper, body []byte) ([]map[string]interface{}, ReplaySide) {
+	items, err := extractSource(s, body)
+	side := ReplaySide{
+		Count:   len(items),
+		Matches: fieldMatches(items, scraperFields(s)),
+	}
+	if err != nil {
+		side.Error = err.Error()
+	}
+	return items, side
+}
+
+// diffArticles compare deux extractions d'une même page, les articles sont associés par leur lien canonique
+// et leur rang parmi les articles ayant ce lien (les articles sans lien ont tous un lien vide)
+func diffArticles(before []map[string]interface{}, after []map[string]interface{}, fields []string) ([]map[string]interface{}, []map[string]interface{}, []ReplayChange) {
+	beforeKeys := articleKeys(before)
+	previous := make(map[string]map[string]interface{}, len(before))
+	for i, item := range before {
+		previous[beforeKeys[i]] = item
+	}
+
+	added := []map[string]interface{}{}
+	changed := []ReplayChange{}
+	matched := make(map[string]bool, len(after))
+	for i, k := range articleKeys(after) {
+		item := after[i]
+		old, ok := previous[k]
+		if !ok {
+			added = append(added, item)
+			continue
+		}
+		matched[k] = true
+
+		diff := make(map[string][2]string)
+		for _, field := range fields {
+			if a, b := itemValue(old, field), itemValue(item, field); a != b {
+				diff[field] = [2]string{a, b}
+			}
+		}
+		if len(diff) > 0 {
+			changed = append(changed, ReplayChange{Link: canonicalLink(itemValue(item, "link")), Fields: diff})
+		}
+	}
+
+	removed := []map[string]interface{}{}
+	for i, item := range before {
+		if !matched[beforeKeys[i]] {
+			removed = append(removed, item)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// articleKeys associe à chaque article son lien canonique suivi de son rang parmi les articles de même lien
+func articleKeys(items []map[string]interface{}) []string {
+	occurrences := make(map[string]int, len(items))
+	keys := make([]string, len(items))
+	for i, item := range items {
+		link := canonicalLink(itemValue(item, "link"))
+		keys[i] = link + "#" + strconv.Itoa(occurrences[link])
+		occurrences[link]++
+	}
+	return keys
+}
+
+// replayCommand implémente go run . replay <scraperID> <schemaID|fichier.json> [N] :
+// le schema candidat est un schema enregistré ou un fichier JSON au format de l'API /schemas
+func replayCommand(args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: go run . replay <scraperID> <schemaID|fichier.json> [N]")
+	}
+
+	scraperID, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Fatalf("ID de scraper invalide: %s", args[0])
+	}
+	limit := 10
+	if len(args) > 2 {
+		if limit, err = strconv.Atoi(args[2]); err != nil || limit <= 0 {
+			log.Fatalf("Nombre de snapshots invalide: %s", args[2])
+		}
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	ctx := context.Background()
+	s, err := client.Scraper.Query().
+		Where(scraper.IDEQ(scraperID)).
+		WithSchema().
+		Only(ctx)
+	if err != nil {
+		log.Fatalf("Scraper %d introuvable: %v", scraperID, err)
+	}
+
+	var candidate *ent.ScraperSchema
+	if schemaID, err := strconv.Atoi(args[1]); err == nil {
+		candidate, err = client.ScraperSchema.Get(ctx, schemaID)
+		if err != nil {
+			log.Fatalf("Schema %d introuvable: %v", schemaID, err)
+		}
+	} else {
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			log.Fatalf("Impossible de lire %s: %v", args[1], err)
+		}
+		var dto ScraperSchemaDTO
+		if err := json.Unmarshal(data, &dto); err != nil {
+			log.Fatalf("Schema JSON invalide dans %s: %v", args[1], err)
+		}
+		if candidate, err = schemaFromDTO(&dto, scraperKind(s)); err != nil {
+			log.Fatalf("Schema candidat invalide: %v", err)
+		}
+	}
+
+	result, err := replaySchema(ctx, client, s, candidate, limit)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if len(result.Snapshots) == 0 {
+		fmt.Printf("📭 Aucun snapshot archivé pour le scraper %s\n", s.Name)
+		return
+	}
+
+	for _, report := range result.Snapshots {
+		fmt.Printf("📸 Exécution %d du %s (snapshot %s)\n", report.RunID, report.FetchedAt.Local().Format("02/01/2006 15:04"), report.Snapshot[:12])
+		fmt.Printf("   actuel: %d articles, candidat: %d articles (+%d -%d ~%d)\n",
+			report.Current.Count, report.Candidate.Count, len(report.Added), len(report.Removed), len(report.Changed))
+		if report.Current.Error != "" {
+			fmt.Printf("   ⚠️ schema actuel: %s\n", report.Current.Error)
+		}
+		if report.Candidate.Error != "" {
+			fmt.Printf("   ⚠️ schema candidat: %s\n", report.Candidate.Error)
+		}
+		for _, item := range report.Added {
+			fmt.Printf("   + %s\n", replayLabel(item))
+		}
+		for _, item := range report.Removed {
+			fmt.Printf("   - %s\n", replayLabel(item))
+		}
+		for _, change := range report.Changed {
+			fmt.Printf("   ~ %s\n", change.Link)
+			fields := make([]string, 0, len(change.Fields))
+			for field := range change.Fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				fmt.Printf("       %s: %q → %q\n", field, change.Fields[field][0], change.Fields[field][1])
+			}
+		}
+	}
+}
+
+// replayLabel décrit un article dans le rapport de replay : titre et lien, ou ce qui en est disponible
+func replayLabel(item map[string]interface{}) string {
+	title, link := itemValue(item, "title"), itemValue(item, "link")
+	switch {
+	case title != "" && link != "":
+		return title + " (" + link + ")"
+	case title != "":
+		return title
+	case link != "":
+		return link
+	}
+	return "(article sans titre ni lien)"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"tidy/ent"
+	"tidy/ent/scraperun"
+	"time"
+)
+
+// scrapeOutcome est le résultat d'une exécution de scraper, enregistré sur son ScrapeRun
+type scrapeOutcome struct {
+	Snapshot   *ent.Snapshot
+	HTTPStatus int // 0 quand il est inconnu
+	Bytes      int
+	Items      []map[string]interface{}
+	NewItems   int
+	Err        error
+}
+
+// startScrapeRun enregistre le début d'une exécution de scraper, job est nil en dehors d'un cron job
+func startScrapeRun(ctx context.Context, client *ent.Client, s *ent.Scraper, job *ent.CronJob, trigger scraperun.Trigger) (*ent.ScrapeRun, error) {
+	create := client.ScrapeRun.Create().
+		SetScraper(s).
+		SetTrigger(trigger)
+	if job != nil {
+		create.SetCronjob(job)
+	}
+
+	run, err := create.Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'enregistrement de l'exécution du scraper %s: %v", s.Name, err)
+	}
+	return run, nil
+}
+
+// failInterruptedRuns marque en échec les exécutions restées en cours après un arrêt du programme.
+// Seules celles commencées depuis plus de RUN_LEASE_MINUTES (30 par défaut) sont concernées :
+// le serveur et le cron peuvent tourner dans deux processus, chacun avec ses exécutions en cours
+func failInterruptedRuns(ctx context.Context, client *ent.Client) (int, error) {
+	lease := time.Duration(intSetting("RUN_LEASE_MINUTES", 30)) * time.Minute
+	updated, err := client.ScrapeRun.Update().
+		Where(
+			scraperun.StatusEQ(scraperun.StatusRunning),
+			scraperun.CreatedAtLT(time.Now().Add(-lease)),
+		).
+		SetStatus(scraperun.StatusFailed).
+		SetError("exécution interrompue par un arrêt du programme").
+		SetFinishedAt(time.Now()).
+		Save(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("erreur lors de la mise à jour des exécutions interrompues: %v", err)
+	}
+	return updated, nil
+}
@@ -2,34 +2,22 @@ package main
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"log"
-	"net/http"
-	"os"
 	"strings"
 	"tidy/ent"
+	"tidy/ent/scraper"
+	"tidy/ent/scraperschema"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/chromedp/chromedp"
-	"github.com/joho/godotenv"
 )
 
-func GetPage(link string)(string) {
-    res, err := http.Get(link)
-    if err != nil {
-        log.Fatal(err)
-    }
-    content, err := io.ReadAll(res.Body)
-    if err != nil {
-        log.Fatal(err)
-    }
-    return string(content)
-}
-
-func GetPagePremium(link string) string {
+// GetPagePremium charge une page dans un navigateur headless pour exécuter son JavaScript
+func GetPagePremium(ctx context.Context, link string) (string, error) {
 	// Création du contexte avec timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout())
 	defer cancel()
 
 	// Création des options pour Chrome headless
@@ -40,7 +28,7 @@ func GetPagePremium(link string) string {
 		chromedp.Flag("disable-dev-shm-usage", true),
 		chromedp.Flag("disable-web-security", true),
 		chromedp.Flag("disable-features", "VizDisplayCompositor"),
-		chromedp.UserAgent("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"),
+		chromedp.UserAgent(userAgent()),
 	)
 
 	// Création de l'allocateur
@@ -66,90 +54,140 @@ func GetPagePremium(link string) string {
 	)
 
 	if err != nil {
-		log.Printf("Erreur lors du chargement de la page %s: %v", link, err)
-		return ""
+		return "", fmt.Errorf("erreur lors du chargement de la page %s: %v", link, err)
 	}
 
 	log.Printf("✅ Page %s chargée avec succès via navigateur headless", link)
-	return html
+	return html, nil
 }
 
-func extractHostname(link string) (hostname string) {
-	return strings.Split(link, "/")[1]
+// scraperKind retourne le type d'un scraper, les anciens scrapers premium sont des pages premium-html
+func scraperKind(scraperDetails *ent.Scraper) scraper.Kind {
+	if scraperDetails.Kind == scraper.KindHTML && scraperDetails.Premium {
+		return scraper.KindPremiumHTML
+	}
+	return scraperDetails.Kind
 }
 
-func personalScraper(scraperDetails *ent.Scraper) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Erreur lors du chargement du .env")
+// parseScraperKind valide le type demandé par l'API, premium sans type correspond à premium-html
+func parseScraperKind(kind string, premium bool) (scraper.Kind, error) {
+	if kind == "" {
+		if premium {
+			return scraper.KindPremiumHTML, nil
+		}
+		return scraper.KindHTML, nil
+	}
+	if err := scraper.KindValidator(scraper.Kind(kind)); err != nil {
+		return "", fmt.Errorf("type de scraper inconnu: %s (html, premium-html, rss, atom, json-feed ou json)", kind)
 	}
-	
-	link := scraperDetails.Link
+	return scraper.Kind(kind), nil
+}
 
-	hostName := extractHostname(link)
+// schemaFormatFor retourne le format de schema attendu par un type de scraper
+func schemaFormatFor(kind scraper.Kind) scraperschema.Format {
+	if kind == scraper.KindJSON {
+		return scraperschema.FormatJSON
+	}
+	return scraperschema.FormatHTML
+}
 
-	var html string
+// isFeedKind indique si un type de scraper est un flux, qui n'a pas besoin de schema
+func isFeedKind(kind scraper.Kind) bool {
+	return kind == scraper.KindRss || kind == scraper.KindAtom || kind == scraper.KindJSONFeed
+}
 
-	if(scraperDetails.Premium) {
-		html = GetPagePremium(link)
-	} else {
-		html = GetPage(link)
+// scrapeSource récupère les articles d'un scraper : flux RSS, Atom ou JSON Feed, API JSON ou page HTML avec son schema
+func scrapeSource(ctx context.Context, scraperDetails *ent.Scraper) ([]map[string]interface{}, error) {
+	body, _, err := fetchSource(ctx, scraperDetails)
+	if err != nil {
+		return nil, err
 	}
+	return extractSource(scraperDetails, body)
+}
 
-    err = os.WriteFile("index.html", []byte(html), 0644)
-    if err != nil {
-		log.Fatal(err)
+// fetchSource télécharge le contenu brut d'un scraper : le flux, la réponse de l'API ou la page HTML.
+// Le statut HTTP vaut 0 quand il est inconnu (navigateur headless, requête impossible)
+func fetchSource(ctx context.Context, scraperDetails *ent.Scraper) ([]byte, int, error) {
+	kind := scraperKind(scraperDetails)
+	if kind == scraper.KindJSON {
+		if err := checkJSONSchema(scraperDetails); err != nil {
+			return nil, 0, err
+		}
+		return fetchJSON(ctx, scraperDetails)
+	}
+	if !isFeedKind(kind) {
+		if err := checkHTMLSchema(scraperDetails); err != nil {
+			return nil, 0, err
+		}
+	}
+	if kind == scraper.KindPremiumHTML {
+		html, err := GetPagePremium(ctx, scraperDetails.Link)
+		return []byte(html), 0, err
 	}
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-    if err != nil {
-        log.Fatal(err)
-    }
-
-	lastBlogs := make([]map[string]interface{}, 0)
-
-    doc.Find(scraperDetails.Edges.Schema.Container).Each(func(i int, s *goquery.Selection) {
-		data := map[string]interface{}{
-			"title":    s.Find(scraperDetails.Edges.Schema.Title).Text(),
-			"description":   s.Find(scraperDetails.Edges.Schema.Description).Text(),
-			"image": func() string {
-				src, _ := s.Find(scraperDetails.Edges.Schema.Image).Attr("src")
-				if(!strings.Contains(src, "https")) {
-					src = "https://" + hostName + "/" + src
-				}
-				return src
-			}(),
-			"time": s.Find(scraperDetails.Edges.Schema.Time).Text(),
-			"link": func() string {
-				src, _ := s.Find(scraperDetails.Edges.Schema.Link).Attr("href")
-				if(!strings.Contains(src, "https")) {
-					src = "https://" + hostName + "/" + src
-				}
-				return src
-			}(),
+	// Les flux gardent leur encodage d'origine, déclaré dans leur en-tête XML
+	response, err := fetchURL(ctx, fetchRequest{
+		URL:       scraperDetails.Link,
+		Headers:   scraperDetails.Headers,
+		Query:     scraperDetails.Query,
+		Transcode: !isFeedKind(kind),
+	})
+	if err != nil {
+		if response != nil {
+			return nil, response.Status, err
 		}
-		lastBlogs = append(lastBlogs, data)
-    })
+		return nil, 0, err
+	}
+	return response.Body, response.Status, nil
+}
 
-	users := getUsers()
+// extractSource extrait les articles du contenu brut d'un scraper, récupéré par fetchSource ou relu depuis un snapshot
+func extractSource(scraperDetails *ent.Scraper, body []byte) ([]map[string]interface{}, error) {
+	kind := scraperKind(scraperDetails)
+	if isFeedKind(kind) {
+		return parseFeed(string(kind), body, scraperDetails.Link)
+	}
+	if kind == scraper.KindJSON {
+		if err := checkJSONSchema(scraperDetails); err != nil {
+			return nil, err
+		}
+		return extractJSON(scraperDetails, body)
+	}
 
-	for _, u := range users {
-		sendMail(u.Email, lastBlogs)
+	if err := checkHTMLSchema(scraperDetails); err != nil {
+		return nil, err
 	}
+	return extractHTML(string(body), scraperDetails.Link, scraperDetails.Edges.Schema)
+}
 
+// checkHTMLSchema vérifie qu'un scraper de page HTML a un schema au format html
+func checkHTMLSchema(scraperDetails *ent.Scraper) error {
+	if scraperDetails.Edges.Schema == nil {
+		return fmt.Errorf("le scraper %s de type %s n'a pas de schema", scraperDetails.Name, scraperKind(scraperDetails))
+	}
+	if scraperDetails.Edges.Schema.Format != scraperschema.FormatHTML {
+		return fmt.Errorf("le scraper %s de type %s doit utiliser un schema au format html", scraperDetails.Name, scraperKind(scraperDetails))
+	}
+	return nil
+}
 
-	// Sauvegarder le tableau complet dans un fichier JSON bien formaté
-	/*
-	jsonData, err := json.MarshalIndent(lastBlogs, "", "  ")
+// extractHTML applique un schema à une page HTML, un article par conteneur trouvé
+func extractHTML(html string, link string, scraperSchema *ent.ScraperSchema) ([]map[string]interface{}, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
-		log.Fatal("Erreur lors de la sérialisation JSON:", err)
+		return nil, fmt.Errorf("HTML invalide pour %s: %v", link, err)
 	}
 
-	err = os.WriteFile("blogs.json", jsonData, 0644)
+	// Les liens relatifs sont résolus par rapport à l'URL de la page ou à son <base href>
+	base, err := pageBaseURL(doc, link)
 	if err != nil {
-		log.Fatal("Erreur lors de l'écriture du fichier:", err)
+		return nil, fmt.Errorf("URL de scraper invalide %s: %v", link, err)
 	}
 
-	fmt.Printf("Données sauvegardées dans blogs.json (%d articles)\n", len(lastBlogs))
-	*/
-}
\ No newline at end of file
+	items := make([]map[string]interface{}, 0)
+	selectNodes(doc.Selection, scraperSchema.Container, scraperSchema.Rules["container"]).Each(func(i int, s *goquery.Selection) {
+		items = append(items, extractArticle(s, scraperSchema, base))
+	})
+
+	return items, nil
+}
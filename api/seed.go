@@ -4,10 +4,12 @@ import (
 	"context"
 	"log"
 	"tidy/ent"
+	"tidy/ent/scraper"
+	"tidy/ent/subscription"
 )
 
 func seedData() {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -15,13 +17,14 @@ func seedData() {
 
 	ctx := context.Background()
 
-	if err := client.Schema.Create(ctx); err != nil {
+	if err := migrateSchema(ctx, client); err != nil {
 		log.Fatalf("failed creating schema resources: %v", err)
 	}
 
 	gamingNewsletter, err := client.Newsletter.Create().
 		SetName("Gaming Newsletter").
 		SetDescription("Newsletter with gaming news.").
+		SetSubject("Dernières actualités gaming ! 🎮").
 		Save(ctx)
 	if err != nil {
 		log.Fatalf("failed creating gaming newsletter: %v", err)
@@ -63,7 +66,7 @@ func seedData() {
 		SetContainer("div.lst_actus div").
 		SetTitle("span.title.block").
 		SetDescription("span.hometext").
-		SetImage("img.lazy.lazyloaded").
+		SetImage("img.lazy").
 		SetTime("span.nobold.black").
 		SetLink("a.block").
 		Save(ctx)
@@ -75,6 +78,7 @@ func seedData() {
 	actuGamingScraper, err := client.Scraper.Create().
 		SetName("Actu Gaming scraper").
 		SetLink("https://www.actugaming.net/actualites").
+		SetKind(scraper.KindHTML).
 		SetPremium(false).
 		SetSchema(actuGamingScraperSchema).
 		Save(ctx)
@@ -85,6 +89,7 @@ func seedData() {
 	jeuxVideoScraper, err := client.Scraper.Create().
 		SetName("Jeux vidéo scraper").
 		SetLink("https://www.jeuxvideo.com/toutes-les-news/").
+		SetKind(scraper.KindPremiumHTML).
 		SetPremium(true).
 		SetSchema(jeuxVideoScraperSchema).
 		Save(ctx)
@@ -95,6 +100,7 @@ func seedData() {
 	jeuxActuScraper, err := client.Scraper.Create().
 		SetName("Jeux Actu scraper").
 		SetLink("https://www.jeuxactu.com/").
+		SetKind(scraper.KindHTML).
 		SetPremium(false).
 		SetSchema(jeuxActuScraperSchema).
 		Save(ctx)
@@ -134,13 +140,23 @@ func seedData() {
 
 	user, err := client.User.Create().
 		SetEmail("tristan.lavocat.pro@gmail.com").
-		SetNewsletter(gamingNewsletter).
 		Save(ctx)
 
 	if err != nil {
 		log.Fatalf("failed creating user: %v", err)
 	}
 
+	// L'abonnement de test est directement actif, sans passer par la confirmation par email
+	_, err = client.Subscription.Create().
+		SetUser(user).
+		SetNewsletter(gamingNewsletter).
+		SetStatus(subscription.StatusActive).
+		Save(ctx)
+
+	if err != nil {
+		log.Fatalf("failed creating subscription: %v", err)
+	}
+
 	log.Printf("✅ Seed terminé avec succès!")
 	log.Printf("📧 Créé %d Newsletter", 1)
 	log.Printf("📊 Créé %d ScraperSchemas", 3)
@@ -156,7 +172,7 @@ func seedData() {
 }
 
 func clearData() {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -164,12 +180,32 @@ func clearData() {
 
 	ctx := context.Background()
 
-	if err := client.Schema.Create(ctx); err != nil {
+	if err := migrateSchema(ctx, client); err != nil {
 		log.Fatalf("failed creating schema resources: %v", err)
 	}
 
 	// Suppression en cascade (grâce aux relations)
-	// Ordre : d'abord les CronJobs, puis les Scrapers, puis les ScraperSchemas, puis les Users, puis les Newsletters
+	// Ordre : d'abord les Deliveries, puis les Articles, puis les ScrapeRuns, puis les Snapshots, puis les CronJobs, puis les Scrapers, puis les ScraperSchemas, puis les Subscriptions, puis les Users, puis les Newsletters
+	_, err = client.Delivery.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting deliveries: %v", err)
+	}
+
+	_, err = client.Article.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting articles: %v", err)
+	}
+
+	_, err = client.ScrapeRun.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting scrape runs: %v", err)
+	}
+
+	_, err = client.Snapshot.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting snapshots: %v", err)
+	}
+
 	_, err = client.CronJob.Delete().Exec(ctx)
 	if err != nil {
 		log.Fatalf("failed deleting cronjobs: %v", err)
@@ -185,6 +221,11 @@ func clearData() {
 		log.Fatalf("failed deleting scraper schemas: %v", err)
 	}
 
+	_, err = client.Subscription.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting subscriptions: %v", err)
+	}
+
 	_, err = client.User.Delete().Exec(ctx)
 	if err != nil {
 		log.Fatalf("failed deleting users: %v", err)
@@ -195,5 +236,10 @@ func clearData() {
 		log.Fatalf("failed deleting newsletters: %v", err)
 	}
 
+	_, err = client.OutboundEmail.Delete().Exec(ctx)
+	if err != nil {
+		log.Fatalf("failed deleting outbound emails: %v", err)
+	}
+
 	log.Printf("🗑️  Toutes les données ont été supprimées")
 } 
\ No newline at end of file
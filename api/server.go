@@ -1,72 +1,170 @@
 package main
 
 import (
+	"html"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"tidy/ent"
+	"tidy/ent/article"
 	"tidy/ent/cronjob"
 	"tidy/ent/newsletter"
+	"tidy/ent/outboundemail"
+	"tidy/ent/schema"
 	"tidy/ent/scraper"
+	"tidy/ent/scraperschema"
+	"tidy/ent/scraperun"
+	"tidy/ent/snapshot"
+	"tidy/ent/subscription"
 	"tidy/ent/user"
+	"time"
 
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqljson"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type ScraperSchemaDTO struct {
 	ID          int    `json:"id"`
+	Format      string `json:"format"`
 	Container   string `json:"container"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Image       string `json:"image"`
 	Time        string `json:"time"`
 	Link        string `json:"link"`
+
+	Rules       map[string]schema.FieldRule `json:"rules,omitempty"`
+	ExtraFields []schema.ExtraField         `json:"extra_fields,omitempty"`
 }
 
 type ScraperDTO struct {
-	ID      int             `json:"id"`
-	Name    string          `json:"name"`
-	Link    string          `json:"link"`
-	Premium bool            `json:"premium"`
-	Schema  *ScraperSchemaDTO `json:"schema,omitempty"`
+	ID       int               `json:"id"`
+	Name     string            `json:"name"`
+	Link     string            `json:"link"`
+	Kind     string            `json:"kind"`
+	Premium  bool              `json:"premium"`
+	Query    map[string]string `json:"query,omitempty"`
+	Degraded bool              `json:"degraded"`
+	Schema   *ScraperSchemaDTO `json:"schema,omitempty"`
+}
+
+// ScraperDetailDTO est un scraper avec les noms de ses en-têtes : leurs valeurs (Authorization, clés d'API...)
+// ne sont jamais renvoyées, et les listes de scrapers n'en donnent pas non plus les noms
+type ScraperDetailDTO struct {
+	*ent.Scraper
+	HeaderNames []string `json:"header_names"`
+}
+
+type ScrapeRunDTO struct {
+	ID          int            `json:"id"`
+	ScraperID   int            `json:"scraper_id,omitempty"`
+	ScraperName string         `json:"scraper_name,omitempty"`
+	CronJobID   int            `json:"cronjob_id,omitempty"`
+	CronJobName string         `json:"cronjob_name,omitempty"`
+	Trigger     string         `json:"trigger"`
+	Status      string         `json:"status"`
+	StartedAt   time.Time      `json:"started_at"`
+	CreatedAt   time.Time      `json:"created_at"` // identique à started_at, conservé pour les clients de /scrapers/:id/health
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+	DurationMs  int64          `json:"duration_ms,omitempty"`
+	HTTPStatus  int            `json:"http_status,omitempty"`
+	Bytes       int            `json:"bytes"`
+	Items       int            `json:"items"`
+	NewItems    int            `json:"new_items"`
+	EmptyFields map[string]int `json:"empty_fields"`
+	Baseline    float64        `json:"baseline,omitempty"`
+	Reasons     []string       `json:"reasons,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Snapshot    string         `json:"snapshot,omitempty"`
+}
+
+type ScraperHealthDTO struct {
+	ScraperID     int            `json:"scraper_id"`
+	Name          string         `json:"name"`
+	Degraded      bool           `json:"degraded"`
+	DegradedSince *time.Time     `json:"degraded_since,omitempty"`
+	Runs          []ScrapeRunDTO `json:"runs"`
 }
 
 type CronJobDTO struct {
-	ID         int         `json:"id"`
-	Name       string      `json:"name"`
-	Time       string      `json:"time"`
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Time       string         `json:"time"`
 	Newsletter *NewsletterDTO `json:"newsletter,omitempty"`
-	Scrapers   []ScraperDTO `json:"scrapers"`
+	Scrapers   []ScraperDTO   `json:"scrapers"`
 }
 
 type NewsletterDTO struct {
-	ID          int         `json:"id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Subject     string       `json:"subject,omitempty"`
+	Template    string       `json:"template,omitempty"`
+	ImageMode   string       `json:"image_mode,omitempty"`
 	Cronjobs    []CronJobDTO `json:"cronjobs"`
 	Users       []UserDTO    `json:"users,omitempty"`
 }
 
 type UserDTO struct {
-	ID         int            `json:"id"`
-	Email      string         `json:"email"`
-	Newsletter *NewsletterDTO `json:"newsletter,omitempty"`
+	ID          int             `json:"id"`
+	Email       string          `json:"email"`
+	Newsletters []NewsletterDTO `json:"newsletters,omitempty"`
+}
+
+type OutboundEmailDTO struct {
+	ID            int        `json:"id"`
+	From          string     `json:"from"`
+	To            string     `json:"to"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	Message       string     `json:"message,omitempty"`
+}
+
+type SubscriptionDTO struct {
+	ID          int                    `json:"id"`
+	Status      string                 `json:"status"`
+	CreatedAt   time.Time              `json:"created_at"`
+	Preferences map[string]interface{} `json:"preferences,omitempty"`
+	Newsletter  *NewsletterDTO         `json:"newsletter,omitempty"`
+}
+
+type ArticleDTO struct {
+	ID          int               `json:"id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Image       string            `json:"image"`
+	Time        string            `json:"time"`
+	Link        string            `json:"link"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	FirstSeenAt time.Time         `json:"first_seen_at"`
+	ScraperID   int               `json:"scraper_id,omitempty"`
 }
 
 func startServer() {
-	err := godotenv.Load()
-	if err != nil {
-	  log.Fatal("Error loading .env file")
-	}
+	requireTokenSecret()
 
 	port := os.Getenv("SERVER_PORT")
 
 	r := gin.Default()
-	r.Use(cors.Default())
+
+	// Comme cors.Default(), en exposant le nombre total d'éléments des listes paginées
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.ExposeHeaders = []string{"X-Total-Count"}
+	r.Use(cors.New(corsConfig))
 
 	// Route de base
 	r.GET("/", func(c *gin.Context) {
@@ -79,6 +177,10 @@ func startServer() {
 	r.GET("/newsletters/:id", getNewsletter)
 	r.PUT("/newsletters/:id", updateNewsletter)
 	r.DELETE("/newsletters/:id", deleteNewsletter)
+	r.GET("/newsletters/:id/template", getNewsletterTemplate)
+	r.PUT("/newsletters/:id/template", updateNewsletterTemplate)
+	r.GET("/newsletters/:id/preview", previewNewsletter)
+	r.GET("/thumbnails", getThumbnail)
 
 	// Routes pour les ScraperSchemas
 	r.POST("/schemas", createScraperSchema)
@@ -93,6 +195,12 @@ func startServer() {
 	r.GET("/scrapers/:id", getScraper)
 	r.PUT("/scrapers/:id", updateScraper)
 	r.DELETE("/scrapers/:id", deleteScraper)
+	r.POST("/scrapers/:id/test", testScraper)
+	r.POST("/scrapers/test", testScraperDraft)
+	r.GET("/scrapers/:id/health", getScraperHealth)
+	r.GET("/snapshots/:hash", getSnapshot)
+	r.POST("/scrapers/:id/replay", replayScraper)
+	r.GET("/scrapers/:id/runs", getScraperRuns)
 
 	// Routes pour les CronJobs
 	r.POST("/cronjobs", createCronJob)
@@ -100,6 +208,8 @@ func startServer() {
 	r.GET("/cronjobs/:id", getCronJob)
 	r.PUT("/cronjobs/:id", updateCronJob)
 	r.DELETE("/cronjobs/:id", deleteCronJob)
+	r.GET("/cronjobs/:id/runs", getCronJobRuns)
+	r.POST("/cronjobs/:id/run", runCronJob)
 
 	// Routes pour les Users
 	r.POST("/users", createUser)
@@ -114,6 +224,25 @@ func startServer() {
 	r.POST("/newsletters/:id/users", addUsersToNewsletter)
 	r.DELETE("/newsletters/:id/users/:userId", removeUserFromNewsletter)
 
+	// Routes pour les abonnements
+	r.GET("/users/:id/subscriptions", getUserSubscriptions)
+	r.POST("/users/:id/subscriptions", addUserSubscription)
+	r.DELETE("/users/:id/subscriptions/:newsletterId", removeUserSubscription)
+
+	// Routes publiques d'abonnement (double opt-in)
+	r.POST("/subscribe", publicSubscribe)
+	r.GET("/subscribe/confirm", confirmSubscriptionHandler)
+	r.GET("/unsubscribe", unsubscribePage)
+	r.POST("/unsubscribe", unsubscribeHandler)
+
+	// Routes pour les articles
+	r.GET("/articles", getArticles)
+
+	// Routes pour la file d'envoi des emails
+	r.GET("/emails", getEmails)
+	r.GET("/emails/:id", getEmail)
+	r.POST("/emails/:id/retry", retryEmail)
+
 	// Routes pour les actions
 	r.POST("/start-cron", startCronHandler)
 	r.POST("/seed", seedHandler)
@@ -134,7 +263,11 @@ func startServer() {
 
 func createNewsletter(c *gin.Context) {
 	var input struct {
-		Name string `json:"name" binding:"required"`
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description" binding:"required"`
+		Subject     string `json:"subject"`
+		Template    string `json:"template"`
+		ImageMode   string `json:"image_mode"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -142,12 +275,30 @@ func createNewsletter(c *gin.Context) {
 		return
 	}
 
+	if err := validateNewsletterTemplate(input.Subject, input.Template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateImageMode(input.ImageMode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	client := getClient()
 	defer client.Close()
 
-	newsletter, err := client.Newsletter.Create().
+	create := client.Newsletter.Create().
 		SetName(input.Name).
-		Save(c.Request.Context())
+		SetDescription(input.Description).
+		SetSubject(input.Subject)
+	if input.Template != "" {
+		create.SetTemplate(input.Template)
+	}
+	if input.ImageMode != "" {
+		create.SetImageMode(newsletter.ImageMode(input.ImageMode))
+	}
+
+	newsletter, err := create.Save(c.Request.Context())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -161,10 +312,14 @@ func getNewsletters(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
+	// Seuls les abonnements actifs font d'un utilisateur un membre de la newsletter
 	newsletters, err := client.Newsletter.Query().
 		WithCronjobs().
-		WithUsers().
-		All(c.Request.Context())	
+		WithSubscriptions(func(q *ent.SubscriptionQuery) {
+			q.Where(subscription.StatusEQ(subscription.StatusActive)).
+				WithUser()
+		}).
+		All(c.Request.Context())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -187,7 +342,8 @@ func getNewsletters(c *gin.Context) {
 
 		// Convertir les utilisateurs
 		var userDTOs []UserDTO
-		for _, user := range newsletter.Edges.Users {
+		for _, sub := range newsletter.Edges.Subscriptions {
+			user := sub.Edges.User
 			userDTO := UserDTO{
 				ID:    user.ID,
 				Email: user.Email,
@@ -199,6 +355,9 @@ func getNewsletters(c *gin.Context) {
 			ID:          newsletter.ID,
 			Name:        newsletter.Name,
 			Description: newsletter.Description,
+			Subject:     newsletter.Subject,
+			Template:    newsletter.Template,
+			ImageMode:   string(newsletter.ImageMode),
 			Cronjobs:    cronJobDTOs,
 			Users:       userDTOs,
 		}
@@ -237,14 +396,17 @@ func getNewsletter(c *gin.Context) {
 		ID:          n.ID,
 		Name:        n.Name,
 		Description: n.Description,
+		Subject:     n.Subject,
+		Template:    n.Template,
+		ImageMode:   string(n.ImageMode),
 		Cronjobs:    []CronJobDTO{},
 	}
 
 	for _, cj := range n.Edges.Cronjobs {
 		cjDTO := CronJobDTO{
-			ID:      cj.ID,
-			Name:    cj.Name,
-			Time:    cj.Time,
+			ID:       cj.ID,
+			Name:     cj.Name,
+			Time:     cj.Time,
 			Scrapers: []ScraperDTO{},
 		}
 		for _, s := range cj.Edges.Scrapers {
@@ -252,20 +414,26 @@ func getNewsletter(c *gin.Context) {
 			if s.Edges.Schema != nil {
 				schema = &ScraperSchemaDTO{
 					ID:          s.Edges.Schema.ID,
+					Format:      string(s.Edges.Schema.Format),
 					Container:   s.Edges.Schema.Container,
 					Title:       s.Edges.Schema.Title,
 					Description: s.Edges.Schema.Description,
 					Image:       s.Edges.Schema.Image,
 					Time:        s.Edges.Schema.Time,
 					Link:        s.Edges.Schema.Link,
+					Rules:       s.Edges.Schema.Rules,
+					ExtraFields: s.Edges.Schema.ExtraFields,
 				}
 			}
 			cjDTO.Scrapers = append(cjDTO.Scrapers, ScraperDTO{
-				ID:      s.ID,
-				Name:    s.Name,
-				Link:    s.Link,
-				Premium: s.Premium,
-				Schema:  schema,
+				ID:       s.ID,
+				Name:     s.Name,
+				Link:     s.Link,
+				Kind:     string(scraperKind(s)),
+				Premium:  s.Premium,
+				Query:    s.Query,
+				Degraded: s.Degraded,
+				Schema:   schema,
 			})
 		}
 		dto.Cronjobs = append(dto.Cronjobs, cjDTO)
@@ -282,7 +450,11 @@ func updateNewsletter(c *gin.Context) {
 	}
 
 	var input struct {
-		Name string `json:"name" binding:"required"`
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		Subject     *string `json:"subject"`
+		Template    string  `json:"template"`
+		ImageMode   string  `json:"image_mode"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -290,12 +462,40 @@ func updateNewsletter(c *gin.Context) {
 		return
 	}
 
+	subject := ""
+	if input.Subject != nil {
+		subject = *input.Subject
+	}
+	if err := validateNewsletterTemplate(subject, input.Template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateImageMode(input.ImageMode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	client := getClient()
 	defer client.Close()
 
-	newsletter, err := client.Newsletter.UpdateOneID(id).
-		SetName(input.Name).
-		Save(c.Request.Context())
+	update := client.Newsletter.UpdateOneID(id)
+	if input.Name != "" {
+		update.SetName(input.Name)
+	}
+	if input.Description != "" {
+		update.SetDescription(input.Description)
+	}
+	if input.Subject != nil {
+		update.SetSubject(*input.Subject)
+	}
+	if input.Template != "" {
+		update.SetTemplate(input.Template)
+	}
+	if input.ImageMode != "" {
+		update.SetImageMode(newsletter.ImageMode(input.ImageMode))
+	}
+
+	newsletter, err := update.Save(c.Request.Context())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -315,7 +515,8 @@ func deleteNewsletter(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	err = client.Newsletter.DeleteOneID(id).Exec(c.Request.Context())
+	// Les abonnements sont supprimés avec la newsletter
+	err = deleteNewsletterWithSubscriptions(c.Request.Context(), client, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -324,16 +525,193 @@ func deleteNewsletter(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Newsletter deleted"})
 }
 
+func getNewsletterTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	n, err := client.Newsletter.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Newsletter not found"})
+		return
+	}
+
+	subject, text, html, err := digestTemplateSources(n)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subject":   subject,
+		"template":  n.Template,
+		"html":      html,
+		"text":      text,
+		"custom":    n.HTMLTemplate != "" || n.TextTemplate != "",
+		"available": digestTemplates(),
+	})
+}
+
+func updateNewsletterTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	// Un champ vide revient au modèle par défaut
+	var input struct {
+		Subject string `json:"subject"`
+		HTML    string `json:"html"`
+		Text    string `json:"text"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	n, err := client.Newsletter.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Newsletter not found"})
+		return
+	}
+
+	// Les modèles sont compilés et exécutés sur des données d'exemple avant d'être enregistrés
+	if err := validateDigestTemplates(n, input.Subject, input.Text, input.HTML); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n, err = n.Update().
+		SetSubject(input.Subject).
+		SetHTMLTemplate(input.HTML).
+		SetTextTemplate(input.Text).
+		Save(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template updated"})
+}
+
+func previewNewsletter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	n, err := client.Newsletter.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Newsletter not found"})
+		return
+	}
+
+	// Nombre d'articles par scraper, 10 par défaut
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	sections, err := latestSections(c.Request.Context(), client, n.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	subject, text, html, err := renderDigest(n, newDigestData(n, "preview@example.com", sections, "#", nil))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("X-Email-Subject", mime.QEncoding.Encode("UTF-8", subject))
+	if c.Query("format") == "text" {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// validateNewsletterTemplate vérifie le modèle de sujet et le nom du modèle d'email d'une newsletter
+func validateNewsletterTemplate(subject string, template string) error {
+	if subject != "" {
+		if _, err := renderSubject(subject, DigestData{}); err != nil {
+			return err
+		}
+	}
+	if template != "" {
+		if err := validateTemplateName(template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateImageMode vérifie le mode d'affichage des images d'une newsletter
+func validateImageMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	return newsletter.ImageModeValidator(newsletter.ImageMode(mode))
+}
+
+// getThumbnail sert la miniature de l'image d'un article pour les newsletters en mode proxy
+func getThumbnail(c *gin.Context) {
+	id, err := verifyToken(c.Query("token"), "thumbnail")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	a, err := client.Article.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+
+	data, err := articleThumbnails.thumbnail(a)
+	if err != nil {
+		// La miniature grise n'est pas mise en cache par le client : l'image peut redevenir disponible
+		log.Printf("⚠️ %v", err)
+		c.Data(http.StatusOK, "image/jpeg", placeholderThumbnail())
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
 // ===== SCRAPER SCHEMAS =====
 
 func createScraperSchema(c *gin.Context) {
 	var input struct {
+		Format      string `json:"format"`
 		Container   string `json:"container" binding:"required"`
 		Title       string `json:"title" binding:"required"`
 		Description string `json:"description" binding:"required"`
 		Image       string `json:"image" binding:"required"`
 		Time        string `json:"time" binding:"required"`
 		Link        string `json:"link" binding:"required"`
+
+		Rules       map[string]schema.FieldRule `json:"rules"`
+		ExtraFields []schema.ExtraField         `json:"extra_fields"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -341,16 +719,44 @@ func createScraperSchema(c *gin.Context) {
 		return
 	}
 
+	// Sélecteurs CSS/XPath, règles et champs supplémentaires sont vérifiés avant l'enregistrement
+	format := scraperschema.FormatHTML
+	if input.Format != "" {
+		format = scraperschema.Format(input.Format)
+		if err := scraperschema.FormatValidator(format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := validateScraperSchema(&ent.ScraperSchema{
+		Format:      format,
+		Container:   input.Container,
+		Title:       input.Title,
+		Description: input.Description,
+		Image:       input.Image,
+		Time:        input.Time,
+		Link:        input.Link,
+		Rules:       input.Rules,
+		ExtraFields: input.ExtraFields,
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	client := getClient()
 	defer client.Close()
 
 	schema, err := client.ScraperSchema.Create().
+		SetFormat(format).
 		SetContainer(input.Container).
 		SetTitle(input.Title).
 		SetDescription(input.Description).
 		SetImage(input.Image).
 		SetTime(input.Time).
 		SetLink(input.Link).
+		SetRules(input.Rules).
+		SetExtraFields(input.ExtraFields).
 		Save(c.Request.Context())
 
 	if err != nil {
@@ -401,12 +807,17 @@ func updateScraperSchema(c *gin.Context) {
 	}
 
 	var input struct {
+		Format      string `json:"format"`
 		Container   string `json:"container"`
 		Title       string `json:"title"`
 		Description string `json:"description"`
 		Image       string `json:"image"`
 		Time        string `json:"time"`
 		Link        string `json:"link"`
+
+		// Remplacent toutes les règles et tous les champs supplémentaires, une valeur vide les supprime
+		Rules       map[string]schema.FieldRule `json:"rules"`
+		ExtraFields []schema.ExtraField         `json:"extra_fields"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -417,7 +828,50 @@ func updateScraperSchema(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	update := client.ScraperSchema.UpdateOneID(id)
+	current, err := client.ScraperSchema.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+
+	// Le schema résultant de la mise à jour partielle est vérifié en entier,
+	// un changement de langage doit rester cohérent avec les sélecteurs existants
+	candidate := *current
+	for _, field := range []struct {
+		value  string
+		target *string
+	}{
+		{input.Container, &candidate.Container},
+		{input.Title, &candidate.Title},
+		{input.Description, &candidate.Description},
+		{input.Image, &candidate.Image},
+		{input.Time, &candidate.Time},
+		{input.Link, &candidate.Link},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if input.Format != "" {
+		candidate.Format = scraperschema.Format(input.Format)
+		if err := scraperschema.FormatValidator(candidate.Format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.Rules != nil {
+		candidate.Rules = input.Rules
+	}
+	if input.ExtraFields != nil {
+		candidate.ExtraFields = input.ExtraFields
+	}
+	if err := validateScraperSchema(&candidate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := client.ScraperSchema.UpdateOneID(id).
+		SetFormat(candidate.Format)
 	if input.Container != "" {
 		update.SetContainer(input.Container)
 	}
@@ -436,6 +890,12 @@ func updateScraperSchema(c *gin.Context) {
 	if input.Link != "" {
 		update.SetLink(input.Link)
 	}
+	if input.Rules != nil {
+		update.SetRules(input.Rules)
+	}
+	if input.ExtraFields != nil {
+		update.SetExtraFields(input.ExtraFields)
+	}
 
 	schema, err := update.Save(c.Request.Context())
 	if err != nil {
@@ -471,8 +931,13 @@ func createScraper(c *gin.Context) {
 	var input struct {
 		Name     string `json:"name" binding:"required"`
 		Link     string `json:"link" binding:"required"`
+		Kind     string `json:"kind"`
 		Premium  bool   `json:"premium"`
-		SchemaID int    `json:"schema_id" binding:"required"`
+		SchemaID *int   `json:"schema_id"`
+
+		// En-têtes et paramètres de requête des sources JSON
+		Headers map[string]string `json:"headers"`
+		Query   map[string]string `json:"query"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -480,28 +945,49 @@ func createScraper(c *gin.Context) {
 		return
 	}
 
-	client := getClient()
-	defer client.Close()
-
-	schema, err := client.ScraperSchema.Get(c.Request.Context(), input.SchemaID)
+	kind, err := parseScraperKind(input.Kind, input.Premium)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Schema not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Les pages HTML ont besoin d'un schema, les flux non
+	if !isFeedKind(kind) && input.SchemaID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schema_id is required for html scrapers"})
 		return
 	}
 
-	scraper, err := client.Scraper.Create().
+	client := getClient()
+	defer client.Close()
+
+	create := client.Scraper.Create().
 		SetName(input.Name).
 		SetLink(input.Link).
-		SetPremium(input.Premium).
-		SetSchema(schema).
-		Save(c.Request.Context())
+		SetKind(kind).
+		SetPremium(kind == scraper.KindPremiumHTML).
+		SetHeaders(input.Headers).
+		SetQuery(input.Query)
+	if input.SchemaID != nil {
+		schema, err := client.ScraperSchema.Get(c.Request.Context(), *input.SchemaID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Schema not found"})
+			return
+		}
+		if schema.Format != schemaFormatFor(kind) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a " + string(kind) + " scraper needs a " + string(schemaFormatFor(kind)) + " schema"})
+			return
+		}
+		create.SetSchema(schema)
+	}
+
+	scraper, err := create.Save(c.Request.Context())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, scraper)
+	c.JSON(http.StatusCreated, toScraperDetailDTO(scraper))
 }
 
 func getScrapers(c *gin.Context) {
@@ -540,7 +1026,17 @@ func getScraper(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, scraper)
+	c.JSON(http.StatusOK, toScraperDetailDTO(scraper))
+}
+
+// toScraperDetailDTO remplace les en-têtes d'un scraper par leurs noms triés
+func toScraperDetailDTO(s *ent.Scraper) ScraperDetailDTO {
+	names := make([]string, 0, len(s.Headers))
+	for name := range s.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ScraperDetailDTO{Scraper: s, HeaderNames: names}
 }
 
 func updateScraper(c *gin.Context) {
@@ -553,8 +1049,13 @@ func updateScraper(c *gin.Context) {
 	var input struct {
 		Name     string `json:"name"`
 		Link     string `json:"link"`
+		Kind     string `json:"kind"`
 		Premium  *bool  `json:"premium"`
 		SchemaID *int   `json:"schema_id"`
+
+		// Remplacent les en-têtes et paramètres existants, un objet vide les supprime
+		Headers map[string]string `json:"headers"`
+		Query   map[string]string `json:"query"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -565,36 +1066,346 @@ func updateScraper(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	update := client.Scraper.UpdateOneID(id)
-	if input.Name != "" {
-		update.SetName(input.Name)
-	}
-	if input.Link != "" {
-		update.SetLink(input.Link)
+	current, err := client.Scraper.Query().
+		Where(scraper.IDEQ(id)).
+		WithSchema().
+		Only(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper not found"})
+		return
 	}
-	if input.Premium != nil {
-		update.SetPremium(*input.Premium)
+
+	// Le type explicite l'emporte, premium seul bascule une page HTML entre html et premium-html
+	kind := scraperKind(current)
+	if input.Kind != "" {
+		kind, err = parseScraperKind(input.Kind, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if input.Premium != nil && !isFeedKind(kind) {
+		kind, _ = parseScraperKind("", *input.Premium)
 	}
+
+	// Schema final du scraper : le nouveau s'il est fourni, l'actuel sinon
+	schema := current.Edges.Schema
 	if input.SchemaID != nil {
-		schema, err := client.ScraperSchema.Get(c.Request.Context(), *input.SchemaID)
+		schema, err = client.ScraperSchema.Get(c.Request.Context(), *input.SchemaID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Schema not found"})
 			return
 		}
-		update.SetSchema(schema)
 	}
 
-	scraper, err := update.Save(c.Request.Context())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if !isFeedKind(kind) {
+		if schema == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "schema_id is required for html scrapers"})
+			return
+		}
+		if schema.Format != schemaFormatFor(kind) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a " + string(kind) + " scraper needs a " + string(schemaFormatFor(kind)) + " schema"})
+			return
+		}
 	}
 
-	c.JSON(http.StatusOK, scraper)
-}
-
-func deleteScraper(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
+	update := client.Scraper.UpdateOneID(id).
+		SetKind(kind).
+		SetPremium(kind == scraper.KindPremiumHTML)
+	if input.Name != "" {
+		update.SetName(input.Name)
+	}
+	if input.Link != "" {
+		update.SetLink(input.Link)
+	}
+	if input.SchemaID != nil {
+		update.SetSchema(schema)
+	}
+	if input.Headers != nil {
+		update.SetHeaders(input.Headers)
+	}
+	if input.Query != nil {
+		update.SetQuery(input.Query)
+	}
+
+	scraper, err := update.Save(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toScraperDetailDTO(scraper))
+}
+
+// testScraper exécute un scraper enregistré sans enregistrer d'articles ni envoyer d'email
+func testScraper(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	s, err := client.Scraper.Query().
+		Where(scraper.IDEQ(id)).
+		WithSchema().
+		Only(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper not found"})
+		return
+	}
+
+	result, err := dryRunScraper(c.Request.Context(), s)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// testScraperDraft teste un lien et un schema fournis dans le corps de la requête, avant de les créer
+func testScraperDraft(c *gin.Context) {
+	var input struct {
+		Link     string            `json:"link" binding:"required"`
+		Kind     string            `json:"kind"`
+		Premium  bool              `json:"premium"`
+		Headers  map[string]string `json:"headers"`
+		Query    map[string]string `json:"query"`
+		SchemaID *int              `json:"schema_id"`
+		Schema   *ScraperSchemaDTO `json:"schema"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kind, err := parseScraperKind(input.Kind, input.Premium)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Le lien est choisi par l'appelant : seules les URL http et https sont récupérées
+	if err := checkSourceLink(input.Link); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link must be an http or https URL"})
+		return
+	}
+
+	draft := &ent.Scraper{
+		Name:    "test",
+		Link:    input.Link,
+		Kind:    kind,
+		Premium: kind == scraper.KindPremiumHTML,
+		Headers: input.Headers,
+		Query:   input.Query,
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	// Schema enregistré ou schema en cours d'écriture
+	switch {
+	case input.Schema != nil:
+		draft.Edges.Schema, err = schemaFromDTO(input.Schema, kind)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case input.SchemaID != nil:
+		draft.Edges.Schema, err = client.ScraperSchema.Get(c.Request.Context(), *input.SchemaID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Schema not found"})
+			return
+		}
+	}
+
+	// Un schema manquant ou d'un autre format est une erreur de la requête, pas de la source
+	if !isFeedKind(kind) {
+		if draft.Edges.Schema == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "schema or schema_id is required for html scrapers"})
+			return
+		}
+		if draft.Edges.Schema.Format != schemaFormatFor(kind) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a " + string(kind) + " scraper needs a " + string(schemaFormatFor(kind)) + " schema"})
+			return
+		}
+	}
+
+	result, err := dryRunScraper(c.Request.Context(), draft)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// getScraperHealth retourne l'état d'un scraper et l'historique de ses dernières exécutions (?limit=, 20 par défaut)
+func getScraperHealth(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	s, err := client.Scraper.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper not found"})
+		return
+	}
+
+	runs, err := withRunEdges(s.QueryRuns()).
+		Order(ent.Desc(scraperun.FieldCreatedAt), ent.Desc(scraperun.FieldID)).
+		Limit(limit).
+		All(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	dto := ScraperHealthDTO{
+		ScraperID:     s.ID,
+		Name:          s.Name,
+		Degraded:      s.Degraded,
+		DegradedSince: s.DegradedSince,
+		Runs:          []ScrapeRunDTO{},
+	}
+	for _, run := range runs {
+		dto.Runs = append(dto.Runs, toScrapeRunDTO(run))
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+// getSnapshot retourne le contenu brut archivé lors d'une exécution, en texte pour ne pas l'interpréter dans le navigateur
+func getSnapshot(c *gin.Context) {
+	client := getClient()
+	defer client.Close()
+
+	snap, err := client.Snapshot.Query().
+		Where(snapshot.HashEQ(c.Param("hash"))).
+		Only(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+
+	content, err := snapshotContent(snap)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain", content)
+}
+
+// replayScraper compare le schema actuel d'un scraper et un schema candidat sur ses derniers snapshots
+func replayScraper(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var input struct {
+		SchemaID *int              `json:"schema_id"`
+		Schema   *ScraperSchemaDTO `json:"schema"`
+		Limit    int               `json:"limit"` // nombre de snapshots comparés, 10 par défaut
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Limit == 0 {
+		input.Limit = 10
+	}
+	if input.Limit < 0 || input.Limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	s, err := client.Scraper.Query().
+		Where(scraper.IDEQ(id)).
+		WithSchema().
+		Only(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper not found"})
+		return
+	}
+
+	var candidate *ent.ScraperSchema
+	switch {
+	case input.Schema != nil:
+		candidate, err = schemaFromDTO(input.Schema, scraperKind(s))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case input.SchemaID != nil:
+		candidate, err = client.ScraperSchema.Get(c.Request.Context(), *input.SchemaID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Schema not found"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schema or schema_id is required"})
+		return
+	}
+
+	result, err := replaySchema(c.Request.Context(), client, s, candidate, input.Limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// schemaFromDTO construit et vérifie un schema non enregistré, au format adapté au type du scraper par défaut
+func schemaFromDTO(dto *ScraperSchemaDTO, kind scraper.Kind) (*ent.ScraperSchema, error) {
+	format := scraperschema.Format(dto.Format)
+	if format == "" {
+		format = schemaFormatFor(kind)
+	}
+	if err := scraperschema.FormatValidator(format); err != nil {
+		return nil, err
+	}
+
+	scraperSchema := &ent.ScraperSchema{
+		Format:      format,
+		Container:   dto.Container,
+		Title:       dto.Title,
+		Description: dto.Description,
+		Image:       dto.Image,
+		Time:        dto.Time,
+		Link:        dto.Link,
+		Rules:       dto.Rules,
+		ExtraFields: dto.ExtraFields,
+	}
+	if err := validateScraperSchema(scraperSchema); err != nil {
+		return nil, err
+	}
+	return scraperSchema, nil
+}
+
+func deleteScraper(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 		return
@@ -603,6 +1414,15 @@ func deleteScraper(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
+	// L'historique des exécutions est supprimé avec le scraper
+	_, err = client.ScrapeRun.Delete().
+		Where(scraperun.HasScraperWith(scraper.IDEQ(id))).
+		Exec(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	err = client.Scraper.DeleteOneID(id).Exec(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -683,20 +1503,26 @@ func getCronJobsAPI(c *gin.Context) {
 		var scrapers []ScraperDTO
 		for _, scraper := range cronJob.Edges.Scrapers {
 			scraperDTO := ScraperDTO{
-				ID:      scraper.ID,
-				Name:    scraper.Name,
-				Link:    scraper.Link,
-				Premium: scraper.Premium,
+				ID:       scraper.ID,
+				Name:     scraper.Name,
+				Link:     scraper.Link,
+				Kind:     string(scraperKind(scraper)),
+				Premium:  scraper.Premium,
+				Query:    scraper.Query,
+				Degraded: scraper.Degraded,
 			}
 			if scraper.Edges.Schema != nil {
 				scraperDTO.Schema = &ScraperSchemaDTO{
 					ID:          scraper.Edges.Schema.ID,
+					Format:      string(scraper.Edges.Schema.Format),
 					Container:   scraper.Edges.Schema.Container,
 					Title:       scraper.Edges.Schema.Title,
 					Description: scraper.Edges.Schema.Description,
 					Image:       scraper.Edges.Schema.Image,
 					Time:        scraper.Edges.Schema.Time,
 					Link:        scraper.Edges.Schema.Link,
+					Rules:       scraper.Edges.Schema.Rules,
+					ExtraFields: scraper.Edges.Schema.ExtraFields,
 				}
 			}
 			scrapers = append(scrapers, scraperDTO)
@@ -796,6 +1622,31 @@ func updateCronJob(c *gin.Context) {
 	c.JSON(http.StatusOK, cronJob)
 }
 
+// runCronJob lance immédiatement les scrapers d'un cron job et l'envoi du récapitulatif, en arrière-plan
+func runCronJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	if _, err := client.CronJob.Get(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CronJob not found"})
+		return
+	}
+
+	go func() {
+		if err := executeCronJobByID(id, scraperun.TriggerManual); err != nil {
+			log.Printf("❌ Erreur lors de l'exécution du cron job %d: %v", id, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "CronJob run started"})
+}
+
 func deleteCronJob(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -820,7 +1671,7 @@ func deleteCronJob(c *gin.Context) {
 func createUser(c *gin.Context) {
 	var input struct {
 		Email        string `json:"email" binding:"required,email"`
-		NewsletterID int    `json:"newsletter_id" binding:"required"`
+		NewsletterID *int   `json:"newsletter_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -831,20 +1682,25 @@ func createUser(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	newsletter, err := client.Newsletter.Get(c.Request.Context(), input.NewsletterID)
+	// Un même email peut suivre plusieurs newsletters : on réutilise l'utilisateur existant
+	user, err := findOrCreateUser(c.Request.Context(), client, input.Email)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Newsletter not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := client.User.Create().
-		SetEmail(input.Email).
-		SetNewsletter(newsletter).
-		Save(c.Request.Context())
+	if input.NewsletterID != nil {
+		newsletter, err := client.Newsletter.Get(c.Request.Context(), *input.NewsletterID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Newsletter not found"})
+			return
+		}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		_, err = requestSubscription(c.Request.Context(), client, user.ID, newsletter.ID, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
 	c.JSON(http.StatusCreated, user)
@@ -855,7 +1711,10 @@ func getUsersAPI(c *gin.Context) {
 	defer client.Close()
 
 	users, err := client.User.Query().
-		WithNewsletter().
+		WithSubscriptions(func(q *ent.SubscriptionQuery) {
+			q.Where(subscription.StatusEQ(subscription.StatusActive)).
+				WithNewsletter()
+		}).
 		All(c.Request.Context())
 
 	if err != nil {
@@ -866,19 +1725,20 @@ func getUsersAPI(c *gin.Context) {
 	// Convertir en DTOs
 	var userDTOs []UserDTO
 	for _, user := range users {
-		var newsletterDTO *NewsletterDTO
-		if user.Edges.Newsletter != nil {
-			newsletterDTO = &NewsletterDTO{
-				ID:          user.Edges.Newsletter.ID,
-				Name:        user.Edges.Newsletter.Name,
-				Description: user.Edges.Newsletter.Description,
-			}
+		var newsletterDTOs []NewsletterDTO
+		for _, sub := range user.Edges.Subscriptions {
+			newsletter := sub.Edges.Newsletter
+			newsletterDTOs = append(newsletterDTOs, NewsletterDTO{
+				ID:          newsletter.ID,
+				Name:        newsletter.Name,
+				Description: newsletter.Description,
+			})
 		}
 
 		userDTO := UserDTO{
-			ID:         user.ID,
-			Email:      user.Email,
-			Newsletter: newsletterDTO,
+			ID:          user.ID,
+			Email:       user.Email,
+			Newsletters: newsletterDTOs,
 		}
 		userDTOs = append(userDTOs, userDTO)
 	}
@@ -898,7 +1758,12 @@ func getUser(c *gin.Context) {
 
 	user, err := client.User.Query().
 		Where(user.IDEQ(id)).
-		WithNewsletter().
+		WithNewsletters(func(q *ent.NewsletterQuery) {
+			q.Where(newsletter.HasSubscriptionsWith(
+				subscription.UserIDEQ(id),
+				subscription.StatusEQ(subscription.StatusActive),
+			))
+		}).
 		Only(c.Request.Context())
 
 	if err != nil {
@@ -917,8 +1782,7 @@ func updateUser(c *gin.Context) {
 	}
 
 	var input struct {
-		Email        string `json:"email"`
-		NewsletterID *int   `json:"newsletter_id"`
+		Email string `json:"email"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -929,18 +1793,11 @@ func updateUser(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
+	// Les newsletters d'un utilisateur se gèrent via /users/:id/subscriptions
 	update := client.User.UpdateOneID(id)
 	if input.Email != "" {
 		update.SetEmail(input.Email)
 	}
-	if input.NewsletterID != nil {
-		newsletter, err := client.Newsletter.Get(c.Request.Context(), *input.NewsletterID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Newsletter not found"})
-			return
-		}
-		update.SetNewsletter(newsletter)
-	}
 
 	user, err := update.Save(c.Request.Context())
 	if err != nil {
@@ -961,7 +1818,8 @@ func deleteUserAPI(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	err = client.User.DeleteOneID(id).Exec(c.Request.Context())
+	// Les abonnements et l'historique des envois sont supprimés avec l'utilisateur
+	err = deleteUserWithSubscriptions(c.Request.Context(), client, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1072,15 +1930,19 @@ func addUsersToNewsletter(c *gin.Context) {
 		return
 	}
 
-	_, err = client.Newsletter.UpdateOneID(newsletterID).
-		AddUsers(users...).
-		Save(c.Request.Context())
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if _, err := client.Newsletter.Get(c.Request.Context(), newsletterID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Newsletter not found"})
 		return
 	}
 
+	// Les utilisateurs déjà abonnés sont ignorés, les autres reçoivent un lien de confirmation
+	for _, u := range users {
+		if _, err := requestSubscription(c.Request.Context(), client, u.ID, newsletterID, nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Users added to Newsletter"})
 }
 
@@ -1100,16 +1962,11 @@ func removeUserFromNewsletter(c *gin.Context) {
 	client := getClient()
 	defer client.Close()
 
-	user, err := client.User.Get(c.Request.Context(), userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+	_, err = unsubscribeUser(c.Request.Context(), client, userID, newsletterID)
+	if ent.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
 		return
 	}
-
-	_, err = client.Newsletter.UpdateOneID(newsletterID).
-		RemoveUsers(user).
-		Save(c.Request.Context())
-
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1118,10 +1975,578 @@ func removeUserFromNewsletter(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User removed from Newsletter"})
 }
 
+// ===== SUBSCRIPTIONS =====
+
+func getUserSubscriptions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	subscriptions, err := client.Subscription.Query().
+		Where(subscription.UserIDEQ(userID)).
+		WithNewsletter().
+		All(c.Request.Context())
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Convertir en DTOs
+	subscriptionDTOs := []SubscriptionDTO{}
+	for _, sub := range subscriptions {
+		subscriptionDTOs = append(subscriptionDTOs, toSubscriptionDTO(sub))
+	}
+
+	c.JSON(http.StatusOK, subscriptionDTOs)
+}
+
+func addUserSubscription(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+
+	var input struct {
+		NewsletterID int                    `json:"newsletter_id" binding:"required"`
+		Preferences  map[string]interface{} `json:"preferences"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	if _, err := client.User.Get(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if _, err := client.Newsletter.Get(c.Request.Context(), input.NewsletterID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Newsletter not found"})
+		return
+	}
+
+	sub, err := requestSubscription(c.Request.Context(), client, userID, input.NewsletterID, input.Preferences)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, err = client.Subscription.Query().
+		Where(subscription.IDEQ(sub.ID)).
+		WithNewsletter().
+		Only(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toSubscriptionDTO(sub))
+}
+
+func removeUserSubscription(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+		return
+	}
+
+	newsletterID, err := strconv.Atoi(c.Param("newsletterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Newsletter ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	// L'abonnement est conservé comme désabonné : un nouvel abonnement devra être confirmé
+	_, err = unsubscribeUser(c.Request.Context(), client, userID, newsletterID)
+	if ent.IsNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription cancelled"})
+}
+
+func toSubscriptionDTO(sub *ent.Subscription) SubscriptionDTO {
+	dto := SubscriptionDTO{
+		ID:          sub.ID,
+		Status:      string(sub.Status),
+		CreatedAt:   sub.CreatedAt,
+		Preferences: sub.Preferences,
+	}
+	if sub.Edges.Newsletter != nil {
+		dto.Newsletter = &NewsletterDTO{
+			ID:          sub.Edges.Newsletter.ID,
+			Name:        sub.Edges.Newsletter.Name,
+			Description: sub.Edges.Newsletter.Description,
+		}
+	}
+	return dto
+}
+
+// ===== PUBLIC SUBSCRIPTION =====
+
+func publicSubscribe(c *gin.Context) {
+	var input struct {
+		Email        string `json:"email" binding:"required,email"`
+		NewsletterID int    `json:"newsletter_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	if _, err := client.Newsletter.Get(c.Request.Context(), input.NewsletterID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Newsletter not found"})
+		return
+	}
+
+	user, err := findOrCreateUser(c.Request.Context(), client, input.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = requestSubscription(c.Request.Context(), client, user.ID, input.NewsletterID, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Même réponse que l'adresse soit déjà abonnée ou non, pour ne pas révéler les abonnés
+	c.JSON(http.StatusAccepted, gin.H{"message": "If this address is not subscribed yet, a confirmation email has been sent"})
+}
+
+func confirmSubscriptionHandler(c *gin.Context) {
+	client := getClient()
+	defer client.Close()
+
+	sub, err := confirmSubscription(c.Request.Context(), client, c.Query("token"))
+	if err != nil {
+		c.Data(http.StatusBadRequest, "text/html; charset=utf-8",
+			[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>❌ Impossible de confirmer l'abonnement</h2><p>"+html.EscapeString(err.Error())+"</p></body></html>"))
+		return
+	}
+
+	if sub.Status == subscription.StatusUnsubscribed {
+		c.Data(http.StatusOK, "text/html; charset=utf-8",
+			[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>Abonnement résilié</h2><p>Vous vous êtes désabonné de cette newsletter depuis l'envoi de ce lien. Abonnez-vous à nouveau pour la recevoir.</p></body></html>"))
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8",
+		[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>✅ Abonnement confirmé</h2><p>Vous recevrez désormais la newsletter.</p></body></html>"))
+}
+
+// unsubscribePage affiche une page de confirmation : un GET ne désinscrit pas, pour ne pas être déclenché par les antivirus qui suivent les liens
+func unsubscribePage(c *gin.Context) {
+	if _, err := verifyToken(c.Query("token"), "unsubscribe"); err != nil {
+		c.Data(http.StatusBadRequest, "text/html; charset=utf-8",
+			[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>❌ Lien de désinscription invalide</h2><p>"+html.EscapeString(err.Error())+"</p></body></html>"))
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8",
+		[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>Se désinscrire de la newsletter ?</h2>"+
+			"<form method=\"POST\" action=\"/unsubscribe?token="+url.QueryEscape(c.Query("token"))+"\">"+
+			"<button type=\"submit\" style=\"background:#007BFF; color:white; border:none; padding:12px 24px; border-radius:4px; cursor:pointer;\">Confirmer la désinscription</button>"+
+			"</form></body></html>"))
+}
+
+// unsubscribeHandler gère la désinscription en un clic (List-Unsubscribe-Post, RFC 8058) et le formulaire de la page
+func unsubscribeHandler(c *gin.Context) {
+	client := getClient()
+	defer client.Close()
+
+	_, err := unsubscribe(c.Request.Context(), client, c.Query("token"))
+	if err != nil {
+		c.Data(http.StatusBadRequest, "text/html; charset=utf-8",
+			[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>❌ Impossible de vous désinscrire</h2><p>"+html.EscapeString(err.Error())+"</p></body></html>"))
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8",
+		[]byte("<html><body style=\"font-family:Arial,sans-serif; text-align:center; padding:40px;\"><h2>✅ Vous êtes désinscrit</h2><p>Vous ne recevrez plus cette newsletter.</p></body></html>"))
+}
+
+// ===== ARTICLES =====
+
+func getArticles(c *gin.Context) {
+	client := getClient()
+	defer client.Close()
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	query := client.Article.Query().
+		WithScraper().
+		Order(ent.Desc(article.FieldFirstSeenAt), ent.Desc(article.FieldID))
+
+	// Filtre optionnel : ?scraper_id=1
+	if scraperID := c.Query("scraper_id"); scraperID != "" {
+		id, err := strconv.Atoi(scraperID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scraper_id"})
+			return
+		}
+		query.Where(article.HasScraperWith(scraper.IDEQ(id)))
+	}
+
+	// Filtres sur les champs supplémentaires : ?extra.category=test&extra.author=Jean
+	for key, values := range c.Request.URL.Query() {
+		name, ok := strings.CutPrefix(key, "extra.")
+		if !ok {
+			continue
+		}
+		if !extraFieldName.MatchString(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid extra field: " + name})
+			return
+		}
+		for _, value := range values {
+			query.Where(func(s *sql.Selector) {
+				s.Where(sqljson.ValueEQ(article.FieldExtra, value, sqljson.Path(name)))
+			})
+		}
+	}
+
+	articles, err := query.Limit(limit).Offset(offset).All(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	articleDTOs := []ArticleDTO{}
+	for _, a := range articles {
+		dto := ArticleDTO{
+			ID:          a.ID,
+			Title:       a.Title,
+			Description: a.Description,
+			Image:       a.Image,
+			Time:        a.Time,
+			Link:        a.Link,
+			Extra:       a.Extra,
+			FirstSeenAt: a.FirstSeenAt,
+		}
+		if a.Edges.Scraper != nil {
+			dto.ScraperID = a.Edges.Scraper.ID
+		}
+		articleDTOs = append(articleDTOs, dto)
+	}
+
+	c.JSON(http.StatusOK, articleDTOs)
+}
+
+// ===== OUTBOUND EMAILS =====
+
+func getEmails(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	query := client.OutboundEmail.Query()
+
+	// Filtre optionnel : ?status=queued|sending|sent|dead
+	if status := c.Query("status"); status != "" {
+		if err := outboundemail.StatusValidator(outboundemail.Status(status)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query.Where(outboundemail.StatusEQ(outboundemail.Status(status)))
+	}
+
+	total, err := query.Clone().Count(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Le contenu des messages n'est servi que par /emails/:id
+	emails, err := query.
+		Order(ent.Desc(outboundemail.FieldID)).
+		Limit(limit).
+		Offset(offset).
+		Select(
+			outboundemail.FieldFrom,
+			outboundemail.FieldTo,
+			outboundemail.FieldSubject,
+			outboundemail.FieldStatus,
+			outboundemail.FieldAttempts,
+			outboundemail.FieldLastError,
+			outboundemail.FieldNextAttemptAt,
+			outboundemail.FieldCreatedAt,
+			outboundemail.FieldSentAt,
+		).
+		All(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	emailDTOs := []OutboundEmailDTO{}
+	for _, email := range emails {
+		emailDTOs = append(emailDTOs, toOutboundEmailDTO(email, false))
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, emailDTOs)
+}
+
+func getEmail(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	email, err := client.OutboundEmail.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toOutboundEmailDTO(email, true))
+}
+
+func retryEmail(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	email, err := client.OutboundEmail.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	if email.Status != outboundemail.StatusDead {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only dead emails can be retried"})
+		return
+	}
+
+	// Les articles d'un récapitulatif remis dans la file sont de nouveau marqués comme envoyés
+	if err := reserveDeliveries(c.Request.Context(), client, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Remettre le message dans la file avec un nouveau compteur de tentatives
+	email, err = email.Update().
+		SetStatus(outboundemail.StatusQueued).
+		SetAttempts(0).
+		SetNextAttemptAt(time.Now()).
+		Save(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toOutboundEmailDTO(email, false))
+}
+
+// ===== SCRAPE RUNS =====
+
+func getScraperRuns(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	if _, err := client.Scraper.Get(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scraper not found"})
+		return
+	}
+
+	listScrapeRuns(c, client.ScrapeRun.Query().Where(scraperun.HasScraperWith(scraper.IDEQ(id))))
+}
+
+func getCronJobRuns(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	if _, err := client.CronJob.Get(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CronJob not found"})
+		return
+	}
+
+	listScrapeRuns(c, client.ScrapeRun.Query().Where(scraperun.HasCronjobWith(cronjob.IDEQ(id))))
+}
+
+// listScrapeRuns répond avec une page d'exécutions, les plus récentes d'abord (?limit=50&offset=0&status=),
+// le nombre total d'exécutions est dans l'en-tête X-Total-Count
+func listScrapeRuns(c *gin.Context, query *ent.ScrapeRunQuery) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	// Filtre optionnel : ?status=running|ok|degraded|failed
+	if status := c.Query("status"); status != "" {
+		if err := scraperun.StatusValidator(scraperun.Status(status)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query.Where(scraperun.StatusEQ(scraperun.Status(status)))
+	}
+
+	total, err := query.Clone().Count(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	runs, err := withRunEdges(query).
+		Order(ent.Desc(scraperun.FieldCreatedAt), ent.Desc(scraperun.FieldID)).
+		Limit(limit).
+		Offset(offset).
+		All(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	runDTOs := []ScrapeRunDTO{}
+	for _, run := range runs {
+		runDTOs = append(runDTOs, toScrapeRunDTO(run))
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, runDTOs)
+}
+
+// withRunEdges charge le scraper, le cron job et le hash du snapshot d'une exécution, sans le contenu compressé
+func withRunEdges(query *ent.ScrapeRunQuery) *ent.ScrapeRunQuery {
+	return query.
+		WithScraper(func(q *ent.ScraperQuery) {
+			q.Select(scraper.FieldName)
+		}).
+		WithCronjob(func(q *ent.CronJobQuery) {
+			q.Select(cronjob.FieldName)
+		}).
+		WithSnapshot(func(q *ent.SnapshotQuery) {
+			q.Select(snapshot.FieldHash)
+		})
+}
+
+func toScrapeRunDTO(run *ent.ScrapeRun) ScrapeRunDTO {
+	dto := ScrapeRunDTO{
+		ID:          run.ID,
+		Trigger:     string(run.Trigger),
+		Status:      string(run.Status),
+		StartedAt:   run.CreatedAt,
+		CreatedAt:   run.CreatedAt,
+		FinishedAt:  run.FinishedAt,
+		HTTPStatus:  run.HTTPStatus,
+		Bytes:       run.Bytes,
+		Items:       run.Items,
+		NewItems:    run.NewItems,
+		EmptyFields: run.EmptyFields,
+		Baseline:    run.Baseline,
+		Reasons:     run.Reasons,
+		Error:       run.Error,
+	}
+	if run.FinishedAt != nil {
+		dto.DurationMs = run.FinishedAt.Sub(run.CreatedAt).Milliseconds()
+	}
+	if run.Edges.Scraper != nil {
+		dto.ScraperID = run.Edges.Scraper.ID
+		dto.ScraperName = run.Edges.Scraper.Name
+	}
+	if run.Edges.Cronjob != nil {
+		dto.CronJobID = run.Edges.Cronjob.ID
+		dto.CronJobName = run.Edges.Cronjob.Name
+	}
+	if run.Edges.Snapshot != nil {
+		dto.Snapshot = run.Edges.Snapshot.Hash
+	}
+	return dto
+}
+
+func toOutboundEmailDTO(email *ent.OutboundEmail, withMessage bool) OutboundEmailDTO {
+	dto := OutboundEmailDTO{
+		ID:            email.ID,
+		From:          email.From,
+		To:            email.To,
+		Subject:       email.Subject,
+		Status:        string(email.Status),
+		Attempts:      email.Attempts,
+		LastError:     email.LastError,
+		NextAttemptAt: email.NextAttemptAt,
+		CreatedAt:     email.CreatedAt,
+		SentAt:        email.SentAt,
+	}
+	if withMessage {
+		dto.Message = string(email.Message)
+	}
+	return dto
+}
+
 // ===== UTILS =====
 
 func getClient() *ent.Client {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		panic(err)
 	}
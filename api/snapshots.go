@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"tidy/ent"
+	"tidy/ent/scraperun"
+	"tidy/ent/snapshot"
+	"time"
+)
+
+// saveSnapshot archive le contenu brut récupéré par un scraper, compressé et identifié par son sha256.
+// Une page identique à un snapshot existant n'est pas stockée une seconde fois
+func saveSnapshot(ctx context.Context, client *ent.Client, body []byte) (*ent.Snapshot, error) {
+	hash := fmt.Sprintf("%x", sha256.Sum256(body))
+
+	existing, err := client.Snapshot.Query().
+		Where(snapshot.HashEQ(hash)).
+		Only(ctx)
+	if err == nil {
+		return existing, nil
+	}
+	if !ent.IsNotFound(err) {
+		return nil, fmt.Errorf("erreur lors de la recherche du snapshot %s: %v", hash, err)
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, fmt.Errorf("erreur lors de la compression du snapshot %s: %v", hash, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("erreur lors de la compression du snapshot %s: %v", hash, err)
+	}
+
+	created, err := client.Snapshot.Create().
+		SetHash(hash).
+		SetData(buf.Bytes()).
+		SetSize(len(body)).
+		Save(ctx)
+	if ent.IsConstraintError(err) {
+		// Le même contenu vient d'être archivé par un autre scraper du cron job
+		return client.Snapshot.Query().Where(snapshot.HashEQ(hash)).Only(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de l'enregistrement du snapshot %s: %v", hash, err)
+	}
+
+	return created, nil
+}
+
+// snapshotContent décompresse le contenu brut d'un snapshot
+func snapshotContent(s *ent.Snapshot) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(s.Data))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s illisible: %v", s.Hash, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s illisible: %v", s.Hash, err)
+	}
+	return content, nil
+}
+
+// pruneSnapshots supprime les snapshots qu'aucune exécution des SNAPSHOT_RETENTION_DAYS derniers jours n'utilise.
+// Les exécutions plus anciennes sont conservées, sans leur contenu brut
+func pruneSnapshots(ctx context.Context, client *ent.Client) (int, error) {
+	cutoff := time.Now().AddDate(0, 0, -intSetting("SNAPSHOT_RETENTION_DAYS", 30))
+
+	deleted, err := client.Snapshot.Delete().
+		Where(
+			snapshot.CreatedAtLT(cutoff),
+			snapshot.Not(snapshot.HasRunsWith(scraperun.CreatedAtGTE(cutoff))),
+		).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("erreur lors de la suppression des anciens snapshots: %v", err)
+	}
+	return deleted, nil
+}
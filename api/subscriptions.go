@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"tidy/ent"
+	"tidy/ent/delivery"
+	"tidy/ent/subscription"
+	"tidy/ent/user"
+	"time"
+)
+
+// findOrCreateUser retourne l'utilisateur correspondant à l'email, en le créant s'il n'existe pas
+func findOrCreateUser(ctx context.Context, client *ent.Client, email string) (*ent.User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	u, err := client.User.Query().
+		Where(user.EmailEQ(email)).
+		Only(ctx)
+	if err == nil {
+		return u, nil
+	}
+	if !ent.IsNotFound(err) {
+		return nil, fmt.Errorf("erreur lors de la récupération de l'utilisateur %s: %v", email, err)
+	}
+
+	u, err = client.User.Create().
+		SetEmail(email).
+		Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la création de l'utilisateur %s: %v", email, err)
+	}
+
+	return u, nil
+}
+
+// subscribeUser abonne un utilisateur à une newsletter, ou met à jour son abonnement s'il existe déjà.
+// Un nouvel abonnement reste en attente ("pending") tant qu'il n'a pas été confirmé par email.
+func subscribeUser(ctx context.Context, client *ent.Client, userID int, newsletterID int, preferences map[string]interface{}) (*ent.Subscription, error) {
+	existing, err := client.Subscription.Query().
+		Where(
+			subscription.UserIDEQ(userID),
+			subscription.NewsletterIDEQ(newsletterID),
+		).
+		Only(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, fmt.Errorf("erreur lors de la récupération de l'abonnement: %v", err)
+	}
+
+	if existing != nil {
+		update := existing.Update()
+		// Un utilisateur désabonné doit confirmer à nouveau son abonnement
+		if existing.Status == subscription.StatusUnsubscribed {
+			update.SetStatus(subscription.StatusPending)
+		}
+		if preferences != nil {
+			update.SetPreferences(preferences)
+		}
+		return update.Save(ctx)
+	}
+
+	create := client.Subscription.Create().
+		SetUserID(userID).
+		SetNewsletterID(newsletterID)
+	if preferences != nil {
+		create.SetPreferences(preferences)
+	}
+
+	return create.Save(ctx)
+}
+
+// requestSubscription abonne un utilisateur à une newsletter et lui envoie le lien de confirmation si nécessaire
+func requestSubscription(ctx context.Context, client *ent.Client, userID int, newsletterID int, preferences map[string]interface{}) (*ent.Subscription, error) {
+	sub, err := subscribeUser(ctx, client, userID, newsletterID, preferences)
+	if err != nil {
+		return nil, err
+	}
+
+	// Les demandes répétées ne renvoient le lien qu'après CONFIRMATION_RESEND_MINUTES (15 par défaut),
+	// pour qu'un formulaire public ne puisse pas servir à inonder une adresse
+	cooldown := time.Duration(intSetting("CONFIRMATION_RESEND_MINUTES", 15)) * time.Minute
+	if sub.Status == subscription.StatusPending &&
+		(sub.ConfirmationSentAt == nil || time.Since(*sub.ConfirmationSentAt) >= cooldown) {
+		if err := sendSubscriptionConfirmation(ctx, client, sub); err != nil {
+			return nil, err
+		}
+		updated, err := sub.Update().
+			SetConfirmationSentAt(time.Now()).
+			Save(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("erreur lors de la mise à jour de l'abonnement %d: %v", sub.ID, err)
+		}
+		sub = updated
+	}
+
+	return sub, nil
+}
+
+// confirmationTTL retourne la durée de validité d'un lien de confirmation (CONFIRMATION_TTL_HOURS, 48h par défaut)
+func confirmationTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("CONFIRMATION_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 48
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// sendSubscriptionConfirmation envoie à l'abonné un lien signé et expirant pour confirmer son abonnement
+func sendSubscriptionConfirmation(ctx context.Context, client *ent.Client, sub *ent.Subscription) error {
+	u, err := client.User.Get(ctx, sub.UserID)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la récupération de l'utilisateur %d: %v", sub.UserID, err)
+	}
+
+	n, err := client.Newsletter.Get(ctx, sub.NewsletterID)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la récupération de la newsletter %d: %v", sub.NewsletterID, err)
+	}
+
+	token, err := signToken("confirm", sub.ID, confirmationTTL())
+	if err != nil {
+		return err
+	}
+
+	return sendConfirmationMail(ctx, client, n, u.Email, publicURL("/subscribe/confirm", token))
+}
+
+// confirmSubscription active l'abonnement correspondant à un lien de confirmation
+func confirmSubscription(ctx context.Context, client *ent.Client, token string) (*ent.Subscription, error) {
+	subscriptionID, err := verifyToken(token, "confirm")
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := client.Subscription.Get(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("abonnement %d introuvable: %v", subscriptionID, err)
+	}
+
+	// Un lien de confirmation ne réactive pas un abonnement désabonné depuis
+	if sub.Status != subscription.StatusPending {
+		return sub, nil
+	}
+
+	return sub.Update().
+		SetStatus(subscription.StatusActive).
+		Save(ctx)
+}
+
+// unsubscribeURL retourne le lien de désinscription signé propre à un abonnement
+func unsubscribeURL(sub *ent.Subscription) (string, error) {
+	token, err := signToken("unsubscribe", sub.ID, 0)
+	if err != nil {
+		return "", err
+	}
+	return publicURL("/unsubscribe", token), nil
+}
+
+// unsubscribe désactive l'abonnement correspondant à un lien de désinscription
+func unsubscribe(ctx context.Context, client *ent.Client, token string) (*ent.Subscription, error) {
+	subscriptionID, err := verifyToken(token, "unsubscribe")
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := client.Subscription.Get(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("abonnement %d introuvable: %v", subscriptionID, err)
+	}
+
+	return markUnsubscribed(ctx, sub)
+}
+
+// unsubscribeUser désabonne un utilisateur d'une newsletter depuis l'API d'administration,
+// comme le lien de désinscription : l'abonnement est conservé avec le statut "unsubscribed"
+func unsubscribeUser(ctx context.Context, client *ent.Client, userID int, newsletterID int) (*ent.Subscription, error) {
+	sub, err := client.Subscription.Query().
+		Where(
+			subscription.UserIDEQ(userID),
+			subscription.NewsletterIDEQ(newsletterID),
+		).
+		Only(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("abonnement de l'utilisateur %d à la newsletter %d introuvable: %w", userID, newsletterID, err)
+	}
+
+	return markUnsubscribed(ctx, sub)
+}
+
+// markUnsubscribed passe un abonnement au statut "unsubscribed"
+func markUnsubscribed(ctx context.Context, sub *ent.Subscription) (*ent.Subscription, error) {
+	if sub.Status == subscription.StatusUnsubscribed {
+		return sub, nil
+	}
+
+	return sub.Update().
+		SetStatus(subscription.StatusUnsubscribed).
+		Save(ctx)
+}
+
+// activeSubscriptions retourne les abonnements actifs d'une newsletter avec leurs utilisateurs
+func activeSubscriptions(ctx context.Context, client *ent.Client, newsletterID int) ([]*ent.Subscription, error) {
+	subscriptions, err := client.Subscription.Query().
+		Where(
+			subscription.NewsletterIDEQ(newsletterID),
+			subscription.StatusEQ(subscription.StatusActive),
+		).
+		WithUser().
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur lors de la récupération des abonnés de la newsletter %d: %v", newsletterID, err)
+	}
+
+	return subscriptions, nil
+}
+
+// deleteUserWithSubscriptions supprime un utilisateur avec ses abonnements et l'historique de ses envois,
+// dans une même transaction pour ne pas laisser d'abonnement orphelin si la suppression échoue
+func deleteUserWithSubscriptions(ctx context.Context, client *ent.Client, userID int) error {
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la suppression de l'utilisateur %d: %v", userID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Subscription.Delete().
+		Where(subscription.UserIDEQ(userID)).
+		Exec(ctx); err != nil {
+		return fmt.Errorf("erreur lors de la suppression des abonnements de l'utilisateur %d: %v", userID, err)
+	}
+	if _, err := tx.Delivery.Delete().
+		Where(delivery.HasUserWith(user.IDEQ(userID))).
+		Exec(ctx); err != nil {
+		return fmt.Errorf("erreur lors de la suppression des envois de l'utilisateur %d: %v", userID, err)
+	}
+	if err := tx.User.DeleteOneID(userID).Exec(ctx); err != nil {
+		return fmt.Errorf("erreur lors de la suppression de l'utilisateur %d: %w", userID, err)
+	}
+
+	return tx.Commit()
+}
+
+// deleteNewsletterWithSubscriptions supprime une newsletter avec ses abonnements, dans une même transaction
+func deleteNewsletterWithSubscriptions(ctx context.Context, client *ent.Client, newsletterID int) error {
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur lors de la suppression de la newsletter %d: %v", newsletterID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Subscription.Delete().
+		Where(subscription.NewsletterIDEQ(newsletterID)).
+		Exec(ctx); err != nil {
+		return fmt.Errorf("erreur lors de la suppression des abonnements de la newsletter %d: %v", newsletterID, err)
+	}
+	if err := tx.Newsletter.DeleteOneID(newsletterID).Exec(ctx); err != nil {
+		return fmt.Errorf("erreur lors de la suppression de la newsletter %d: %w", newsletterID, err)
+	}
+
+	return tx.Commit()
+}
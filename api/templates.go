@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"tidy/ent"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// defaultSubject est utilisé quand la newsletter n'a pas de sujet personnalisé
+const defaultSubject = "Dernières actualités de {{.Newsletter.Name}}"
+
+// DigestData contient les données passées aux modèles du récapitulatif.
+// Les modèles peuvent être personnalisés : ils ne reçoivent que des copies des champs à afficher, pas les entités ent
+type DigestData struct {
+	Newsletter     NewsletterView
+	Email          string
+	Sections       []SectionView
+	Total          int
+	UnsubscribeURL string
+	Date           time.Time
+	Images         map[int]string // source des images par article, l'image distante par défaut
+}
+
+// NewsletterView contient les champs d'une newsletter affichés par les modèles
+type NewsletterView struct {
+	Name        string
+	Description string
+}
+
+// SectionView contient les champs d'une section du récapitulatif affichés par les modèles
+type SectionView struct {
+	Source   string
+	Site     string
+	Articles []ArticleView
+}
+
+// ArticleView contient les champs d'un article affichés par les modèles, id sert uniquement à ImageSrc
+type ArticleView struct {
+	id          int
+	Title       string
+	Description string
+	Image       string
+	Time        string
+	Link        string
+	Extra       map[string]string
+	FirstSeenAt time.Time
+}
+
+func newNewsletterView(n *ent.Newsletter) NewsletterView {
+	return NewsletterView{Name: n.Name, Description: n.Description}
+}
+
+// newDigestData construit les données du récapitulatif d'une newsletter à partir de ses sections
+func newDigestData(n *ent.Newsletter, email string, sections []DigestSection, unsubscribeURL string, images map[int]string) DigestData {
+	views := make([]SectionView, 0, len(sections))
+	for _, section := range sections {
+		articles := make([]ArticleView, 0, len(section.Articles))
+		for _, a := range section.Articles {
+			extra := make(map[string]string, len(a.Extra))
+			for name, value := range a.Extra {
+				extra[name] = value
+			}
+			articles = append(articles, ArticleView{
+				id:          a.ID,
+				Title:       a.Title,
+				Description: a.Description,
+				Image:       a.Image,
+				Time:        a.Time,
+				Link:        a.Link,
+				Extra:       extra,
+				FirstSeenAt: a.FirstSeenAt,
+			})
+		}
+		views = append(views, SectionView{Source: section.Source, Site: section.Site, Articles: articles})
+	}
+
+	return DigestData{
+		Newsletter:     newNewsletterView(n),
+		Email:          email,
+		Sections:       views,
+		Total:          digestSize(sections),
+		UnsubscribeURL: unsubscribeURL,
+		Date:           time.Now(),
+		Images:         images,
+	}
+}
+
+// ImageSrc retourne la source à utiliser pour l'image d'un article dans les modèles.
+// Les sources générées (cid:, miniatures) sont marquées sûres, html/template filtrerait le schéma cid:
+func (d DigestData) ImageSrc(a ArticleView) interface{} {
+	if src, ok := d.Images[a.id]; ok {
+		return htmltemplate.URL(src)
+	}
+	return a.Image
+}
+
+// ConfirmationData contient les données passées aux modèles de l'email de confirmation
+type ConfirmationData struct {
+	Newsletter NewsletterView
+	Email      string
+	ConfirmURL string
+}
+
+// ScraperAlertData contient les données passées aux modèles de l'alerte envoyée à l'administrateur
+type ScraperAlertData struct {
+	Scraper   *ent.Scraper
+	Run       *ent.ScrapeRun
+	Failures  int // nombre d'échecs consécutifs pour une alerte d'échec, 0 pour un scraper dégradé
+	HealthURL string
+}
+
+var templateFuncs = map[string]interface{}{
+	"anchor": sectionAnchor,
+}
+
+// digestTemplates retourne les noms des modèles de récapitulatif disponibles dans templates/digest
+func digestTemplates() []string {
+	files, _ := fs.Glob(templateFS, "templates/digest/*.html.tmpl")
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(path.Base(file), ".html.tmpl"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateTemplateName vérifie qu'un modèle de récapitulatif existe
+func validateTemplateName(name string) error {
+	for _, available := range digestTemplates() {
+		if available == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("modèle d'email inconnu: %s (disponibles: %s)", name, strings.Join(digestTemplates(), ", "))
+}
+
+// renderSubject exécute le modèle text/template du sujet
+func renderSubject(source string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.New("subject").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("modèle de sujet invalide: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("erreur lors du rendu du sujet: %v", err)
+	}
+
+	// Un sujet tient sur une seule ligne
+	return strings.Join(strings.Fields(buf.String()), " "), nil
+}
+
+// builtinTemplate retourne le source d'un modèle de templates/<kind>/<name>.<ext>.tmpl
+func builtinTemplate(kind string, name string, ext string) (string, error) {
+	content, err := templateFS.ReadFile(path.Join("templates", kind, name+"."+ext+".tmpl"))
+	if err != nil {
+		return "", fmt.Errorf("modèle %s/%s.%s introuvable", kind, name, ext)
+	}
+	return string(content), nil
+}
+
+// executeTemplates exécute un modèle texte et un modèle HTML donnés par leur source
+func executeTemplates(textSource string, htmlSource string, data interface{}) (string, string, error) {
+	textTmpl, err := texttemplate.New("text").Funcs(templateFuncs).Parse(textSource)
+	if err != nil {
+		return "", "", fmt.Errorf("modèle texte invalide: %v", err)
+	}
+
+	htmlTmpl, err := htmltemplate.New("html").Funcs(templateFuncs).Parse(htmlSource)
+	if err != nil {
+		return "", "", fmt.Errorf("modèle HTML invalide: %v", err)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return "", "", fmt.Errorf("erreur lors du rendu du modèle texte: %v", err)
+	}
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return "", "", fmt.Errorf("erreur lors du rendu du modèle HTML: %v", err)
+	}
+
+	return text.String(), html.String(), nil
+}
+
+// renderTemplates exécute la version texte et la version HTML d'un modèle de templates/<kind>/<name>
+func renderTemplates(kind string, name string, data interface{}) (string, string, error) {
+	textSource, err := builtinTemplate(kind, name, "txt")
+	if err != nil {
+		return "", "", err
+	}
+	htmlSource, err := builtinTemplate(kind, name, "html")
+	if err != nil {
+		return "", "", err
+	}
+
+	return executeTemplates(textSource, htmlSource, data)
+}
+
+// digestTemplateSources retourne le sujet et les modèles texte et HTML utilisés par une newsletter :
+// les modèles personnalisés s'ils existent, sinon ceux de templates/digest
+func digestTemplateSources(n *ent.Newsletter) (string, string, string, error) {
+	subject := n.Subject
+	if subject == "" {
+		subject = defaultSubject
+	}
+
+	name := n.Template
+	if name == "" {
+		name = "default"
+	}
+
+	text := n.TextTemplate
+	if text == "" {
+		builtin, err := builtinTemplate("digest", name, "txt")
+		if err != nil {
+			return "", "", "", err
+		}
+		text = builtin
+	}
+
+	html := n.HTMLTemplate
+	if html == "" {
+		builtin, err := builtinTemplate("digest", name, "html")
+		if err != nil {
+			return "", "", "", err
+		}
+		html = builtin
+	}
+
+	return subject, text, html, nil
+}
+
+// renderDigest génère le sujet, la version texte et la version HTML du récapitulatif d'une newsletter avec ses modèles
+func renderDigest(n *ent.Newsletter, data DigestData) (string, string, string, error) {
+	subjectSource, textSource, htmlSource, err := digestTemplateSources(n)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	subject, err := renderSubject(subjectSource, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	text, html, err := executeTemplates(textSource, htmlSource, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return subject, text, html, nil
+}
+
+// sampleUnsubscribeURL est le lien de désinscription des données d'exemple, que tout modèle doit afficher
+const sampleUnsubscribeURL = "https://example.com/unsubscribe?token=exemple"
+
+// sampleDigestData retourne des données d'exemple pour valider un modèle avant de l'enregistrer
+func sampleDigestData(n *ent.Newsletter) DigestData {
+	sections := []DigestSection{
+		{
+			Source: "Exemple",
+			Site:   "example.com",
+			Articles: []*ent.Article{
+				{
+					Title:       "Titre de l'article",
+					Description: "Description de l'article",
+					Image:       "https://example.com/image.jpg",
+					Time:        "01/01/2025, 12:00",
+					Link:        "https://example.com/article",
+					Extra:       map[string]string{"author": "Auteur"},
+					FirstSeenAt: time.Now(),
+				},
+			},
+		},
+	}
+
+	return newDigestData(n, "exemple@example.com", sections, sampleUnsubscribeURL, nil)
+}
+
+// validateDigestTemplates vérifie qu'un sujet et des modèles personnalisés se compilent et s'exécutent,
+// et que les deux versions du récapitulatif contiennent le lien de désinscription
+func validateDigestTemplates(n *ent.Newsletter, subject string, text string, html string) error {
+	candidate := *n
+	candidate.Subject = subject
+	candidate.TextTemplate = text
+	candidate.HTMLTemplate = html
+
+	_, renderedText, renderedHTML, err := renderDigest(&candidate, sampleDigestData(&candidate))
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(renderedText, sampleUnsubscribeURL) {
+		return fmt.Errorf("le modèle texte doit afficher le lien de désinscription {{.UnsubscribeURL}}")
+	}
+	if !strings.Contains(renderedHTML, sampleUnsubscribeURL) {
+		return fmt.Errorf("le modèle HTML doit afficher le lien de désinscription {{.UnsubscribeURL}}")
+	}
+	return nil
+}
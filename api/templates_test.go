@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"tidy/ent"
+)
+
+func TestRenderDigest(t *testing.T) {
+	n := &ent.Newsletter{Name: "Veille", Template: "default"}
+	sections := []DigestSection{
+		{
+			Source: "Blog",
+			Site:   "example.com",
+			Articles: []*ent.Article{
+				{ID: 1, Title: "Premier", Image: "https://example.com/1.jpg", Link: "https://example.com/1"},
+				{ID: 2, Title: "Second", Image: "https://example.com/2.jpg", Link: "https://example.com/2"},
+			},
+		},
+	}
+
+	subject, text, html, err := renderDigest(n, newDigestData(n, "user@example.com", sections, "https://example.com/unsubscribe", map[int]string{1: "cid:image-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "Dernières actualités de Veille" {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{"Premier", "Second", "https://example.com/unsubscribe"} {
+		if !strings.Contains(text, want) || !strings.Contains(html, want) {
+			t.Errorf("%q missing from the rendered digest", want)
+		}
+	}
+	// L'image jointe remplace l'image distante, les autres restent distantes
+	if !strings.Contains(html, `src="cid:image-1"`) || !strings.Contains(html, `src="https://example.com/2.jpg"`) {
+		t.Errorf("image sources not rendered from Images:\n%s", html)
+	}
+}
+
+func TestDigestTemplatesOnlySeeViews(t *testing.T) {
+	for _, source := range []string{
+		"{{.Newsletter.HTMLTemplate}} {{.UnsubscribeURL}}",
+		"{{.Newsletter.QueryUsers}} {{.UnsubscribeURL}}",
+		"{{range .Sections}}{{range .Articles}}{{.Edges}}{{end}}{{end}} {{.UnsubscribeURL}}",
+	} {
+		n := &ent.Newsletter{Name: "Veille", Template: "default"}
+		if err := validateDigestTemplates(n, "", source, source); err == nil {
+			t.Errorf("template %q accepted, want an error", source)
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 )
 
 func testRelations() {
-	client, err := ent.Open("sqlite3", "file:test.db?_fk=1")
+	client, err := ent.Open("sqlite3", databaseDSN)
 	if err != nil {
 		log.Fatalf("failed opening connection to sqlite: %v", err)
 	}
@@ -53,6 +53,7 @@ func testRelations() {
 	for _, scraper := range scrapers {
 		fmt.Printf("Scraper: %s (ID: %d)\n", scraper.Name, scraper.ID)
 		fmt.Printf("  Link: %s\n", scraper.Link)
+		fmt.Printf("  Kind: %s\n", scraperKind(scraper))
 		fmt.Printf("  Premium: %t\n", scraper.Premium)
 		if scraper.Edges.Schema != nil {
 			fmt.Printf("  Schema: Container=%s, Title=%s\n", 
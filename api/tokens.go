@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidToken = errors.New("lien invalide")
+	errExpiredToken = errors.New("lien expiré")
+)
+
+// tokenSecret retourne la clé utilisée pour signer les liens envoyés par email
+func tokenSecret() ([]byte, error) {
+	secret := os.Getenv("TOKEN_SECRET")
+	if secret == "" {
+		return nil, errors.New("TOKEN_SECRET n'est pas défini dans le .env")
+	}
+	return []byte(secret), nil
+}
+
+// requireTokenSecret arrête le programme si TOKEN_SECRET n'est pas défini : sans lui aucun lien de confirmation
+// ni de désinscription ne peut être généré, et chaque récapitulatif serait ignoré
+func requireTokenSecret() {
+	if _, err := tokenSecret(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// signToken génère un jeton signé pour une action sur un ID, dont l'objet dépend de l'action :
+// un abonnement pour "confirm" et "unsubscribe", un article pour "thumbnail".
+// Un ttl nul produit un jeton sans date d'expiration.
+func signToken(purpose string, id int, ttl time.Duration) (string, error) {
+	secret, err := tokenSecret()
+	if err != nil {
+		return "", err
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	payload := fmt.Sprintf("%s:%d:%d", purpose, id, expiresAt)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyToken vérifie la signature, l'action et l'expiration d'un jeton et retourne l'ID signé pour cette action
+func verifyToken(token string, purpose string) (int, error) {
+	secret, err := tokenSecret()
+	if err != nil {
+		return 0, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, errInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return 0, errInvalidToken
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 || fields[0] != purpose {
+		return 0, errInvalidToken
+	}
+
+	id, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, errInvalidToken
+	}
+	if expiresAt > 0 && time.Now().Unix() > expiresAt {
+		return 0, errExpiredToken
+	}
+
+	return id, nil
+}
+
+// publicURL construit une URL publique de l'API à partir de PUBLIC_URL
+func publicURL(path string, token string) string {
+	return publicBaseURL() + path + "?token=" + token
+}
+
+// publicBaseURL retourne l'URL publique de l'API, sans / final
+func publicBaseURL() string {
+	base := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if base == "" {
+		base = "http://localhost:" + os.Getenv("SERVER_PORT")
+	}
+	return base
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// trackingParams sont retirés des liens pour qu'un même article partagé avec des paramètres de suivi reste unique
+var trackingParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "fbclid", "gclid"}
+
+// canonicalLink normalise un lien pour qu'un même article ait toujours la même clé
+func canonicalLink(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(link)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// Port par défaut inutile
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		removed := false
+		for _, param := range trackingParams {
+			if query.Has(param) {
+				query.Del(param)
+				removed = true
+			}
+		}
+		if removed {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// pageBaseURL retourne l'URL de référence des liens relatifs d'une page, en tenant compte de <base href>
+func pageBaseURL(doc *goquery.Document, pageURL string) (*url.URL, error) {
+	base, err := url.Parse(strings.TrimSpace(pageURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	return base, nil
+}
+
+// resolveURL résout un lien relatif (../, //host, ?query) par rapport à l'URL de base.
+// Seuls les liens http(s) sont conservés.
+func resolveURL(base *url.URL, raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	resolved := ref
+	if base != nil {
+		resolved = base.ResolveReference(ref)
+	}
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+
+	return canonicalLink(resolved.String())
+}
+
+// lazyImageAttrs sont les attributs utilisés par les scripts de chargement différé, par ordre de priorité
+var lazyImageAttrs = []string{"data-src", "data-lazy-src", "data-original", "data-echo", "src"}
+
+// imageURL retourne l'URL absolue de l'image d'une sélection (img, picture ou conteneur d'une image)
+func imageURL(s *goquery.Selection, base *url.URL) string {
+	if !s.Is("img, source") {
+		if img := s.Find("img").First(); img.Length() > 0 {
+			s = img
+		}
+	}
+
+	for _, attr := range lazyImageAttrs {
+		value, _ := s.Attr(attr)
+		// Les data: sont des images de remplacement en attendant le chargement
+		if value == "" || strings.HasPrefix(strings.TrimSpace(value), "data:") {
+			continue
+		}
+		if src := resolveURL(base, value); src != "" {
+			return src
+		}
+	}
+
+	for _, attr := range []string{"data-srcset", "srcset"} {
+		if value, ok := s.Attr(attr); ok {
+			if src := resolveURL(base, largestSrcset(value)); src != "" {
+				return src
+			}
+		}
+	}
+
+	// <picture> : les <source> précèdent l'<img>
+	if picture := s.Closest("picture"); picture.Length() > 0 {
+		if value, ok := picture.Find("source[srcset]").First().Attr("srcset"); ok {
+			return resolveURL(base, largestSrcset(value))
+		}
+	}
+
+	return ""
+}
+
+// largestSrcset retourne la plus grande image d'un attribut srcset ("a.jpg 320w, b.jpg 640w" ou "a.jpg 1x, b.jpg 2x")
+func largestSrcset(srcset string) string {
+	type candidate struct {
+		url  string
+		size float64
+	}
+
+	// Une URL peut contenir des virgules (data:image/...;base64,...) : seule une virgule
+	// qui suit l'URL et ses descripteurs sépare deux images
+	var candidates []candidate
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			break
+		}
+
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		src := rest[:end]
+		rest = rest[end:]
+
+		var descriptors string
+		if trimmed := strings.TrimRight(src, ","); trimmed != src {
+			// Virgule collée à l'URL : pas de descripteur
+			src = trimmed
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			descriptors, rest = rest[:comma], rest[comma+1:]
+		} else {
+			descriptors, rest = rest, ""
+		}
+
+		if src == "" || strings.HasPrefix(src, "data:") {
+			continue
+		}
+
+		size := 1.0
+		if fields := strings.Fields(descriptors); len(fields) > 0 {
+			// Descripteur de largeur (w) ou de densité (x)
+			descriptor := strings.TrimRight(fields[0], "wx")
+			if value, err := strconv.ParseFloat(descriptor, 64); err == nil {
+				size = value
+			}
+		}
+		candidates = append(candidates, candidate{url: src, size: size})
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+	return candidates[0].url
+}
+
+// linkURL retourne l'URL absolue du lien d'une sélection, le lien englobant ou contenu si elle n'a pas de href
+func linkURL(s *goquery.Selection, base *url.URL) string {
+	href, ok := s.Attr("href")
+	if !ok {
+		if a := s.Closest("a[href]"); a.Length() > 0 {
+			href, _ = a.Attr("href")
+		} else {
+			href, _ = s.Find("a[href]").First().Attr("href")
+		}
+	}
+
+	return resolveURL(base, href)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestCanonicalLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://example.com/post", "https://example.com/post"},
+		{"  https://example.com/post  ", "https://example.com/post"},
+		{"HTTPS://Example.COM/Post", "https://example.com/Post"},
+		{"https://example.com", "https://example.com/"},
+		{"https://example.com/post#comments", "https://example.com/post"},
+		{"https://example.com:443/post", "https://example.com/post"},
+		{"http://example.com:80/post", "http://example.com/post"},
+		{"https://example.com:8443/post", "https://example.com:8443/post"},
+		{"https://example.com/post?utm_source=rss&utm_medium=feed", "https://example.com/post"},
+		{"https://example.com/post?id=3&fbclid=abc&gclid=def", "https://example.com/post?id=3"},
+		{"https://example.com/post?b=2&a=1", "https://example.com/post?b=2&a=1"},
+		{"/relative/post", "/relative/post"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalLink(tt.link); got != tt.want {
+			t.Errorf("canonicalLink(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalLinkIsIdempotent(t *testing.T) {
+	for _, link := range []string{
+		"HTTPS://Example.COM:443/post?utm_campaign=x&id=1#top",
+		"http://example.com",
+	} {
+		once := canonicalLink(link)
+		if twice := canonicalLink(once); twice != once {
+			t.Errorf("canonicalLink(%q) = %q, canonicalLink again = %q", link, once, twice)
+		}
+	}
+}
+
+func TestPageBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		pageURL string
+		href    string
+		want    string
+	}{
+		{"without base", `<a href="post">x</a>`, "https://example.com/blog/", "post", "https://example.com/blog/post"},
+		{"parent path", `<a href="../post">x</a>`, "https://example.com/blog/list", "../post", "https://example.com/post"},
+		{"absolute base", `<base href="https://cdn.example.com/news/">`, "https://example.com/blog/", "post", "https://cdn.example.com/news/post"},
+		{"relative base", `<base href="/news/">`, "https://example.com/blog/", "post", "https://example.com/news/post"},
+		{"protocol-relative link", `<base href="/news/">`, "https://example.com/blog/", "//other.com/post", "https://other.com/post"},
+		{"query only", ``, "https://example.com/list?page=1", "?page=2", "https://example.com/list?page=2"},
+		{"non-http link", ``, "https://example.com/", "mailto:a@example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, err := pageBaseURL(doc, tt.pageURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resolveURL(base, tt.href); got != tt.want {
+				t.Errorf("resolveURL(%q) = %q, want %q", tt.href, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLargestSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   string
+	}{
+		{"a.jpg 320w, b.jpg 640w, c.jpg 480w", "b.jpg"},
+		{"a.jpg 1x, b.jpg 2x", "b.jpg"},
+		{"a.jpg", "a.jpg"},
+		{"data:image/gif;base64,R0lGOD 1x, b.jpg 1x", "b.jpg"},
+		{"a.jpg 640w, b.jpg 640w", "a.jpg"},
+		{"a.jpg 1x,b.jpg 2x", "b.jpg"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := largestSrcset(tt.srcset); got != tt.want {
+			t.Errorf("largestSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+func TestImageURL(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"src", `<div class="item"><img src="/img/a.jpg"></div>`, "https://example.com/img/a.jpg"},
+		{"lazy placeholder", `<div class="item"><img src="data:image/gif;base64,R0lGOD" data-src="a.jpg"></div>`, "https://example.com/blog/a.jpg"},
+		{"srcset", `<div class="item"><img srcset="small.jpg 320w, large.jpg 1024w"></div>`, "https://example.com/blog/large.jpg"},
+		{"picture source", `<div class="item"><picture><source srcset="//cdn.example.com/a.webp 1x, //cdn.example.com/b.webp 2x"><img></picture></div>`, "https://cdn.example.com/b.webp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, err := pageBaseURL(doc, "https://example.com/blog/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := imageURL(doc.Find(".item"), base); got != tt.want {
+				t.Errorf("imageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
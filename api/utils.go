@@ -1,125 +1,164 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
-	"net/smtp"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
 	"os"
-
-	"github.com/joho/godotenv"
+	"sort"
+	"tidy/ent"
+	"tidy/ent/newsletter"
+	"time"
 )
 
-func sendMail(to string, blogs []map[string]interface{}) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Erreur lors du chargement du .env")
+// sendMail met en file le récapitulatif d'un abonné, il est envoyé par le worker de la file d'envoi.
+// Les articles en attente sont marqués comme envoyés avec la mise en file. Le cache d'images est partagé entre les abonnés d'un même cron job
+func sendMail(ctx context.Context, client *ent.Client, n *ent.Newsletter, u *ent.User, unsubscribeURL string, sections []DigestSection, pending []*ent.Article, cache *imageCache) error {
+	from := os.Getenv("SMTP_EMAIL")
+	to := u.Email
+
+	var sources map[int]string
+	var images []inlineImage
+	switch n.ImageMode {
+	case newsletter.ImageModeInline:
+		sources, images = inlineImages(cache, sections)
+	case newsletter.ImageModeProxy:
+		var err error
+		sources, err = proxiedImages(sections)
+		if err != nil {
+			return err
+		}
 	}
 
-	from := os.Getenv("SMTP_EMAIL")
-	password := os.Getenv("SMTP_PASSWORD")
-	serverName := os.Getenv("SMTP_SERVER_NAME")
-	port := os.Getenv("SMTP_PORT")
-
-	// Création du contenu HTML avec tableau des blogs
-	tableRows := ""
-	for _, blog := range blogs {
-		title := fmt.Sprintf("%v", blog["title"])
-		description := fmt.Sprintf("%v", blog["description"])
-		image := fmt.Sprintf("%v", blog["image"])
-		time := fmt.Sprintf("%v", blog["time"])
-		link := fmt.Sprintf("%v", blog["link"])
-		
-		tableRows += fmt.Sprintf(`
-			<tr style="border-bottom: 1px solid #ddd;">
-				<td style="padding: 12px; text-align: center;">
-					<img src="%s" alt="Image" style="max-width: 100px; height: auto; border-radius: 4px;">
-				</td>
-				<td style="padding: 12px;">
-					<h3 style="margin: 0 0 8px 0; color: #007BFF;">%s</h3>
-					<p style="margin: 0; color: #666; font-size: 14px;">%s</p>
-					<p style="margin: 4px 0 0 0; color: #999; font-size: 12px;">📅 %s</p>
-					<a href="%s" style="color: #007BFF; text-decoration: none; font-size: 12px;">🔗 Lire l'article</a>
-				</td>
-			</tr>`, image, title, description, time, link)
+	subject, text, html, err := renderDigest(n, newDigestData(n, to, sections, unsubscribeURL, sources))
+	if err != nil {
+		return err
 	}
 
-	// Création du message HTML
-	message := []byte(fmt.Sprintf(
-		"From: %s\r\n"+
-			"To: %s\r\n"+
-			"Subject: Dernières actualités gaming ! 🎮\r\n"+
-			"MIME-Version: 1.0\r\n"+
-			"Content-Type: text/html; charset=\"UTF-8\"\r\n"+
-			"\r\n"+
-			"<html><body style=\"font-family:Arial,sans-serif; color:#333; margin: 0; padding: 20px;\">\r\n"+
-			"<div style=\"max-width: 800px; margin: 0 auto; background: #f9f9f9; padding: 20px; border-radius: 8px;\">\r\n"+
-			"<table style=\"width: 100%%; border-collapse: collapse; background: white; border-radius: 8px; overflow: hidden; box-shadow: 0 2px 10px rgba(0,0,0,0.1);\">\r\n"+
-			"<thead>\r\n"+
-			"<tr style=\"background: #007BFF; color: white;\">\r\n"+
-			"<th style=\"padding: 15px; text-align: center; width: 120px;\">Image</th>\r\n"+
-			"<th style=\"padding: 15px; text-align: left;\">Article</th>\r\n"+
-			"</tr>\r\n"+
-			"</thead>\r\n"+
-			"<tbody>\r\n"+
-			"%s\r\n"+
-			"</tbody>\r\n"+
-			"</table>\r\n"+
-			"<div style=\"text-align: center; margin-top: 30px; padding-top: 20px; border-top: 1px solid #ddd;\">\r\n"+
-			"<p style=\"font-size: 12px; color: #888;\">📧 Message automatique généré par TritouBot </p>\r\n"+
-			"<p style=\"font-size: 12px; color: #888;\">Total d'articles : <strong>%d</strong></p>\r\n"+
-			"</div>\r\n"+
-			"</div>\r\n"+
-			"</body></html>\r\n",
-		from, to, tableRows, len(blogs)))
-
-	// Connexion SSL directe sur port
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: false,
-		ServerName:         serverName,
+	message := buildMessage(from, to, subject, map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, text, html, images)
+
+	if _, err := enqueueDigest(ctx, client, from, u, subject, message, pending); err != nil {
+		return err
 	}
 
-	conn, err := tls.Dial("tcp", (serverName+":"+port), tlsconfig)
+	log.Println("✅ E-mail récapitulatif mis en file pour", to)
+	return nil
+}
+
+// sendConfirmationMail met en file le lien de confirmation d'un abonnement (double opt-in)
+func sendConfirmationMail(ctx context.Context, client *ent.Client, n *ent.Newsletter, to string, confirmURL string) error {
+	from := os.Getenv("SMTP_EMAIL")
+	subject := "Confirmez votre abonnement à " + n.Name
+
+	text, html, err := renderTemplates("confirmation", "default", ConfirmationData{
+		Newsletter: newNewsletterView(n),
+		Email:      to,
+		ConfirmURL: confirmURL,
+	})
 	if err != nil {
-		log.Fatal("Erreur TLS Dial :", err)
+		return err
 	}
 
-	// Création du client SMTP à partir de la connexion sécurisée
-	client, err := smtp.NewClient(conn, serverName)
-	if err != nil {
-		log.Fatal("Erreur création client SMTP :", err)
+	message := buildMessage(from, to, subject, nil, text, html, nil)
+
+	if _, err := enqueueEmail(ctx, client, from, to, subject, message); err != nil {
+		return err
 	}
 
-	// Authentification
-	auth := smtp.PlainAuth("", from, password, serverName)
-	if err = client.Auth(auth); err != nil {
-		log.Fatal("Erreur authentification SMTP :", err)
+	log.Println("✅ E-mail de confirmation mis en file pour", to)
+	return nil
+}
+
+// buildMessage construit un message multipart/alternative avec une version texte et une version HTML.
+// Avec des images jointes, le message devient multipart/related : l'alternative puis les images cid:
+func buildMessage(from string, to string, subject string, headers map[string]string, text string, html string, images []inlineImage) []byte {
+	var alternative bytes.Buffer
+	altWriter := multipart.NewWriter(&alternative)
+
+	writePart(altWriter, "text/plain; charset=\"UTF-8\"", text)
+	writePart(altWriter, "text/html; charset=\"UTF-8\"", html)
+	altWriter.Close()
+
+	body := alternative
+	contentType := fmt.Sprintf("multipart/alternative; boundary=\"%s\"", altWriter.Boundary())
+
+	if len(images) > 0 {
+		var related bytes.Buffer
+		writer := multipart.NewWriter(&related)
+
+		part, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {contentType},
+		})
+		part.Write(alternative.Bytes())
+
+		for _, img := range images {
+			writeInlineImage(writer, img)
+		}
+		writer.Close()
+
+		body = related
+		contentType = fmt.Sprintf("multipart/related; type=\"multipart/alternative\"; boundary=\"%s\"", writer.Boundary())
 	}
 
-	// Préparation de l'e-mail
-	if err = client.Mail(from); err != nil {
-		log.Fatal("Erreur MAIL FROM :", err)
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+
+	// En-têtes supplémentaires dans un ordre stable
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
 	}
-	if err = client.Rcpt(to); err != nil {
-		log.Fatal("Erreur RCPT TO :", err)
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&message, "%s: %s\r\n", key, headers[key])
 	}
 
-	// Envoi du corps du message
-	w, err := client.Data()
-	if err != nil {
-		log.Fatal("Erreur ouverture Data :", err)
-	}
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&message, "\r\n")
+	message.Write(body.Bytes())
 
-	_, err = w.Write(message)
-	if err != nil {
-		log.Fatal("Erreur écriture du message :", err)
-	}
+	return message.Bytes()
+}
 
-	err = w.Close()
-	if err != nil {
-		log.Fatal("Erreur fermeture Data :", err)
-	}
+// writePart ajoute une partie encodée en quoted-printable à un message multipart
+func writePart(writer *multipart.Writer, contentType string, content string) {
+	part, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
 
-	client.Quit()
-	log.Println("✅ E-mail avec tableau des blogs envoyé avec succès")
+	qp := quotedprintable.NewWriter(part)
+	qp.Write([]byte(content))
+	qp.Close()
+}
+
+// writeInlineImage ajoute une image encodée en base64, référencée dans le HTML par son Content-ID
+func writeInlineImage(writer *multipart.Writer, img inlineImage) {
+	part, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {img.ContentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-ID":                {"<" + img.ContentID + ">"},
+		"Content-Disposition":       {"inline"},
+	})
+
+	// Lignes de 76 caractères maximum (RFC 2045)
+	encoded := base64.StdEncoding.EncodeToString(img.Data)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
 }
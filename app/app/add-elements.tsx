@@ -12,11 +12,11 @@ interface Newsletter {
 interface User {
   id: number;
   email: string;
-  newsletter?: {
+  newsletters?: {
     id: number;
     name: string;
     description: string;
-  };
+  }[];
 }
 
 interface Scraper {
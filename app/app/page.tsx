@@ -53,19 +53,20 @@ interface CronJob {
 interface User {
   id: number;
   email: string;
-  newsletter?: {
+  newsletters?: {
     id: number;
     name: string;
     description: string;
-  };
+  }[];
 }
 
 interface CronTask {
   id: number;
   name: string;
   time: string;
-  scraper_id: number;
-  scraper_name: string;
+  cronjob_id: number;
+  scraper_ids: number[];
+  scraper_names: string[];
   status: string;
   last_run?: string;
   next_run?: string;
@@ -1029,7 +1030,9 @@ export default function Home() {
                           </h3>
                           <div className="mt-2 text-sm text-gray-500">
                             <span>
-                              📧 Newsletter: {user.newsletter?.name || "N/A"}
+                              📧 Newsletters:{" "}
+                              {user.newsletters?.map((n) => n.name).join(", ") ||
+                                "N/A"}
                             </span>
                           </div>
                         </div>
@@ -1127,7 +1130,7 @@ export default function Home() {
                             </span>
                           </div>
                           <p className="text-gray-600 mb-2">
-                            ⏰ {task.time} | 🕷️ {task.scraper_name}
+                            ⏰ {task.time} | 🕷️ {task.scraper_names.join(", ")}
                           </p>
                           <div className="grid grid-cols-1 md:grid-cols-2 gap-4 text-sm text-gray-500">
                             <div>